@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// attachBuffer is how many lines are held for an attached autocmd that is
+// not keeping up.  Further lines are dropped until it catches up.
+const attachBuffer = 1000
+
+// attached holds the lines to send to each of the autocmds attached to this
+// one, which are sent everything this autocmd writes.  It is protected by
+// outputMu.
+var attached = map[net.Conn]chan []byte{}
+
+// writeAttached queues p to be written to each attached autocmd.  Lines
+// for one that cannot keep up are dropped rather than holding up autocmd.
+// outputMu must be held.
+func writeAttached(p []byte) {
+	for _, lines := range attached {
+		select {
+		case lines <- p:
+		default:
+		}
+	}
+}
+
+// serveAttach sends the output of this autocmd to c until c is closed.
+func serveAttach(c net.Conn) {
+	c.SetDeadline(time.Time{})
+	lines := make(chan []byte, attachBuffer)
+	outputMu.Lock()
+	attached[c] = lines
+	outputMu.Unlock()
+	go func() {
+		for p := range lines {
+			if _, err := c.Write(p); err != nil {
+				c.Close()
+			}
+		}
+	}()
+	// Nothing more is expected from c.
+	io.Copy(io.Discard, c)
+	c.Close()
+	outputMu.Lock()
+	delete(attached, c)
+	outputMu.Unlock()
+	close(lines)
+}
+
+// attach attaches to the autocmd running in the current directory and
+// copies its output to w until it exits.  Each line read from in triggers
+// the set it names, or the first set if it is empty, as autocmd --trigger
+// does.
+func attach(w io.Writer, in io.Reader) error {
+	c, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return fmt.Errorf("cannot attach: %v", err)
+	}
+	defer c.Close()
+	fmt.Fprintln(c, "attach")
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			resp, err := sendControl(append([]string{"trigger"}, strings.Fields(scanner.Text())...)...)
+			switch {
+			case err != nil:
+				fmt.Fprintln(os.Stderr, err)
+			case resp != "ok":
+				fmt.Fprintln(os.Stderr, resp)
+			}
+		}
+	}()
+	io.Copy(w, c)
+	fmt.Fprintln(w, "autocmd has exited")
+	return nil
+}
@@ -10,6 +10,22 @@
 // option causes autocmd wait for the first change to the file before executing
 // the command.
 //
+// The --state flag saves what autocmd has seen in a file when a command is
+// run and when autocmd exits, and reloads it when autocmd starts in the same
+// directory.  A set found in the state file starts as with --wait, so
+// restarting autocmd does not run everything again.  With --run-missed such a
+// set instead runs only if its files changed while autocmd was not running.
+// On macOS the state also records the FSEvents event ID, and files FSEvents
+// reports as changed since then count as changed even if they look the same.
+//
+//	autocmd --state=.autocmd.state --run-missed '*.go' -- go test
+//
+// The --state, --history-file, and --log-file flags may be given @ rather than
+// a file, e.g., --state=@, in which case the file is kept in a directory for
+// the current directory under $XDG_STATE_HOME/autocmd
+// ($HOME/.local/state/autocmd if $XDG_STATE_HOME is not set).  The directories
+// of these files are created as needed.
+//
 // The --go flag is a short cut to specify --clear and all .go files from the
 // current directory on down.  The --go flag implies --, typical usage;
 //
@@ -23,10 +39,512 @@
 //
 // This will cause autocmd to run goyacc if grammer.y changes and go build if
 // any .go file changes.  If grammar.y changes then grammer.go will change which
-// will trigger the go build.
+// will trigger the go build.  When several sets have changes at the same time,
+// each of them runs in turn.  A change detected while a command is running
+// kills the running command.
+//
+// The command may contain placeholders that are replaced each time the
+// command is run: {file} is the first file that was added or changed, {files}
+// is all of them, {dir}, {base}, and {ext} are the directory, name without
+// extension, and extension of {file}, and {time} is the current time.  A word
+// that is just {files} becomes one word per file.  For example:
+//
+//	autocmd '.../*.md' -- pandoc {file} -o out/{base}.html
+//
+// With --per-file the command is run once for each file that was added or
+// changed, with up to --jobs commands running at once.  The placeholders refer
+// to just that file.  If the command does not contain {file}, {dir}, {base},
+// or {ext} then the name of the file is appended to the command:
+//
+//	autocmd --per-file --jobs=4 '.../*.scss' -- sass {file} css/{base}.css
+//
+// The --keep-alive flag starts each command only once.  When files later
+// change, rather than restarting the command, autocmd writes a line to the
+// command's standard input listing the files that were added or changed,
+// separated by spaces.  This suits tools that are slow to start but can
+// rerun on demand.  If the command exits, or stops reading its standard
+// input, it is started again on the next change.  --timeout does not apply
+// to these commands.
+//
+// The --listen flag is for commands that are servers.  autocmd listens on the
+// TCP address, e.g., --listen=:8080, and passes the listening socket to the
+// command as file descriptor 3 using the systemd socket activation protocol
+// ($LISTEN_FDS and $LISTEN_PID).  Only the last command of a pipeline
+// (cmd1 ++ cmd2) is given the socket.  As autocmd keeps the socket open,
+// clients connecting while the server restarts wait for the new server rather
+// than being refused.  The socket cannot be passed to commands run with
+// --docker, --kubectl, or --sync-exec on another host.
+//
+// The --proxy flag runs an HTTP reverse proxy in front of a server started by
+// the command, e.g., --proxy=':3000->:8080'.  Requests to the first address
+// are forwarded to the second.  While the server is not accepting
+// connections, e.g., because it is restarting, requests are held for up to
+// 30 seconds until it is, so a browser refreshed right after a change does
+// not see an error.
+//
+// The --livereload flag runs a server on the address, e.g.,
+// --livereload=:35729, that tells browsers to reload the page each time a
+// command succeeds.  A page opts in by including the script the server
+// provides:
+//
+//	<script src="http://localhost:35729/livereload.js"></script>
+//
+// The script listens for server-sent events from /events on the server.
+//
+// The --healthcheck flag checks that a command that starts a server has
+// started successfully.  The check is either a URL, which must return a 2xx
+// status, or a shell command, which must exit 0.  The check is repeated until
+// it passes or --healthcheck-timeout (30 seconds by default) passes, in which
+// case the server is reported as unhealthy, though it is not killed.  A check
+// command that runs longer than --healthcheck-timeout is killed.  With
+// --proxy, requests are held until the check passes, and with --livereload,
+// browsers are reloaded when the check passes.
+//
+//	autocmd --healthcheck=http://localhost:8080/healthz .../*.go -- go run .
+//
+// A command may be a pipeline of several commands separated by "++".  The
+// commands are run one at a time, stopping at the first command that fails:
+//
+//	autocmd --go -- go vet ./... ++ go test ./...
+//
+// A set may be preceded by set options of the form KEY=VALUE.  The name
+// option names the set, which is then used in messages and to refer to the
+// set (e.g., with --trigger):
+//
+//	autocmd name=parser grammar.y -- goyacc -o grammar.go grammar.y \
+//		--- name=build .../*.go -- go build
+//
+// Sets that are not named are referred to by their number, starting at 1.
+// With --go, set options precede the command.
+//
+// The after option lists the sets, separated by commas, that must complete
+// before the set runs.  When several of these sets have changes, they run one
+// at a time in dependency order.  When a set completes, the sets that depend
+// on it are checked for changes immediately rather than at the next check:
+//
+//	autocmd name=proto '*.proto' -- protoc --go_out=. foo.proto \
+//		--- after=proto .../*.go -- go build
+//
+// The produces option lists patterns, separated by commas, of the files the
+// set's command produces.  Changes the command makes to these files do not
+// cause the set to run again, but do cause other sets watching the files to
+// run.  This breaks cycles between sets that generate each other's inputs:
+//
+//	autocmd name=api produces=api.pb.go api.proto -- protoc --go_out=. api.proto \
+//		--- name=docs produces=api.proto api.pb.go -- ./gendocs
+//
+// The on=commit option causes the set to run each time a git commit is made
+// in the repository containing the current directory, rather than when files
+// change.  Such a set has no patterns.  This permits a slow set to run only on
+// commit while a fast set runs on every change:
+//
+//	autocmd .../*.go -- go test ./... --- on=commit -- ./validate.sh
+//
+// The frequency=DUR option checks the files of the set every DUR rather than
+// every --frequency.  This permits a cheap set to be checked often while a set
+// whose patterns cover a large tree is checked less often, reducing the cost
+// of checking it:
+//
+//	autocmd frequency=250ms '*.go' -- go vet \
+//		--- frequency=10s '.../*' -- ./integration.sh
+//
+// The every=DUR option also runs the set every DUR even if none of its files
+// have changed, counting from when it last started or was last due, e.g., if
+// its if= command failed.  A set with every= need not have any patterns,
+// which permits autocmd to run periodic commands, such as fetching from a git
+// remote, alongside its watched ones:
+//
+//	autocmd '*.go' -- go test ./... \
+//		--- every=1h -- ./integration.sh \
+//		--- every=5m -- git fetch
+//
+// The url=URL option, whose value may be a comma separated list, also runs
+// the set when the resource at URL, an http or https URL, changes.  URLs are
+// fetched every 10 seconds, using the ETag and Last-Modified headers to avoid
+// refetching an unchanged resource, and a change is a change in the body.  A
+// set with url= need not have any patterns.  The --url flag, which may be
+// repeated, watches URL for all sets.  For example, to regenerate a client
+// when the API it uses changes:
+//
+//	autocmd url=https://api.example.com/openapi.json -- make client
+//
+// The if=CMD option runs the shell command CMD when the set's sources have
+// changed, including after a set it runs after, and only runs the set if CMD
+// succeeds.  CMD fails if it runs for more than 10 seconds.  It must be a
+// single word, so it is usually quoted.  A set that is run by hand, e.g., with
+// --trigger, runs regardless.  For example, to only run the integration tests
+// when there are changes to the protocol buffers, or when on AC power:
+//
+//	autocmd '.../*.go' -- go test ./... \
+//		--- "if=! git diff --quiet -- '*.proto'" '.../*' -- ./integration.sh \
+//		--- if=on_ac_power '.../*.go' -- ./bench.sh
+//
+// Patterns are normally relative to the current directory.  The --root flag,
+// which may be repeated, causes patterns to be relative to each of the
+// specified directories instead.  This watches several directories, such as
+// sibling repositories, with the same patterns.  Commands are still run in
+// the current directory:
+//
+//	autocmd --root ../libfoo --root . '.../*.go' -- go build
+//
+// The --root-detect flag changes to the root of the project containing the
+// current directory before doing anything else: the closest directory,
+// starting with the current directory, that contains a .autocmd, go.mod, or
+// .git.  Patterns, the config, and commands are then relative to the root, so
+// the same command works from anywhere in the project.  Paths given to flags,
+// e.g., --config, --state, and --root, are still relative to the directory
+// autocmd was started in:
+//
+//	autocmd --root-detect --go go test ./...
+//
+// A leading ~ or ~USER in a pattern, --root, --exclude, or a config go,
+// exclude, or include line is replaced by the home directory, and $VAR or
+// ${VAR} by the value of the environment variable VAR, even when the shell
+// did not expand them because they were quoted or are in a config file.  An
+// unset variable is left as is.  The --no-expand flag turns this off:
+//
+//	go: $PROJ_ROOT/.../*.go
+//
+// If the current directory or a --root directory disappears, e.g., a branch
+// without it is checked out, autocmd waits for it to return and then
+// rescans.  If the current directory is replaced by a new directory of the
+// same name autocmd changes to the new one.  With --dir-wait autocmd exits if
+// a directory is missing for longer than the specified duration.
+//
+// The --exclude flag, which may be repeated, prevents files matching the
+// pattern from being watched.  An element of "..." in an exclude pattern
+// matches any number of directories and a pattern without a / is matched
+// against the file's name:
+//
+//	autocmd --exclude '.../testdata/...' --exclude '*_test.go' --go go build
+//
+// A command that modifies the files it watches, e.g., a code generator, will
+// trigger itself.  The --ignore-own-output flag ignores changes to files that
+// were modified while a command was running.  Since this also ignores files
+// saved by the user during that time, the --output flag, which may be
+// repeated, can be used instead to name the files commands produce.  Only
+// changes to files matching an output pattern made while a command was
+// running are ignored.  Output patterns are matched like --exclude patterns:
+//
+//	autocmd --output '*.pb.go' .../*.proto .../*.go -- go generate ./...
+//
+// A set that still triggers itself runs over and over.  A set that runs more
+// than --loop-rate (10) times a minute for --loop-time (2 minutes) is paused
+// with a warning.  It runs again when it is triggered by autocmd --trigger,
+// SIGUSR1, or r in --tui.  --loop-rate=0 never pauses a set.
+//
+// A file is normally considered changed when its size or modification time
+// changes.  A modification time that goes backwards, e.g., when a file is
+// restored from a backup or an older commit is checked out, is a change.
+// With --stat=ctime a file is also considered changed when its inode change
+// time changes, which catches tools that preserve the modification time.
+// With --stat=full a file is additionally considered changed when its
+// permissions or owner change, or it is replaced by a different file (e.g.,
+// by cp -p or mv), even if its size and modification time are the same.
+//
+// Some file systems, such as FAT and some NFS servers, record modification
+// times with a coarse granularity, so a file written twice in quick
+// succession may appear unchanged.  With --mtime-granularity, e.g.,
+// --mtime-granularity=2s, the contents of any file modified within that
+// duration of being checked, or with a modification time in the future due
+// to clock skew, are hashed and compared at the next check.
+//
+// The --network-fs flag tunes change detection for files on network file
+// systems such as NFS, SMB, and 9p.  The default --frequency becomes 2
+// seconds and the default --mtime-granularity becomes 2 seconds.  A file
+// whose modification time changes but whose size and contents do not is not
+// considered changed (each file is read when it is first seen so its
+// contents are known).  A failed stat is retried, and a file that still
+// cannot be stat'ed, e.g., due to a transient ESTALE error, is assumed to be
+// unchanged rather than removed.
+//
+// The --profile flag reports, to standard error, how long each pass over the
+// watched files took and how much of that was spent stat'ing files, followed
+// by how long each pattern took to expand and how many files it matched.
+// This helps find the patterns that make checking slow.
+//
+// The bench subcommand measures how long it takes to check the files matched
+// by the patterns, or the patterns of the config file if none are given:
+//
+//	autocmd bench '.../*.go'
+//
+// It makes --bench-passes passes (10 by default) over the files and reports
+// the minimum, average, and maximum time a pass took, along with the memory
+// allocated by each pass.  This is useful for choosing --frequency.
+//
+// The --timeout flag limits how long a command may run, an hour by default.
+// Normally a command that runs too long is killed.  With --timeout-action=warn
+// a message is printed and the command is allowed to continue.  The shell
+// command specified by --on-timeout is run before the command is killed (or
+// warned about).  The process ID of the command, which is also its process
+// group ID, is in $AUTOCMD_PID.  The --on-timeout command, and anything it
+// started, is killed if it runs for more than a minute.  For example, to get
+// a stack dump from a hung go test:
+//
+//	autocmd --timeout=5m --on-timeout='pkill -QUIT -g $AUTOCMD_PID; sleep 1' \
+//		--go go test
+//
+// Walking the directory trees expanded by "..." is expensive so the list of
+// directories found is remembered.  The tree is walked again when the
+// modification time of one of its directories changes, which is the case when
+// entries are added, removed, or renamed, or when the --rescan interval has
+// passed.  Likewise, the files in a directory matching a pattern are only
+// looked for again when the directory's modification time changes.  The
+// --rescan=0 flag causes the tree to be walked, and directories read, on
+// every check.
+//
+// If autocmd notices that much more time has passed between checks than
+// expected, e.g., because the machine was asleep, it discards what it has
+// remembered about directories and rescans everything.  On macOS (when built
+// with cgo) it instead asks FSEvents what changed while it was asleep and
+// only discards what is affected.
+//
+// Directories named node_modules, .hg, .svn, target, or dist are not
+// descended into when expanding "...", nor are .git directories unless --git
+// is specified.  The --ignore-dir flag, which may be repeated, adds to these
+// names, and may be a pattern such as 'build-*'.  The --no-default-ignores
+// flag removes the defaults.
+//
+// A file named .autocmdignore lists, using the syntax of .gitignore, files and
+// directories that are not watched.  Its rules apply to the directory
+// containing it and everything below, so they can be kept with the files they
+// affect.  Rules in deeper .autocmdignore files take precedence, and ! negates
+// a rule.  Ignored directories are not descended into when expanding "...":
+//
+//	# testdata/.autocmdignore
+//	*.golden
+//	!keep.golden
+//	generated/
+//
+// To prevent accidentally walking an enormous tree, such as running autocmd
+// from $HOME, the walk stops after visiting --max-files files and directories
+// (100,000 by default) and does not descend more than --max-depth directories
+// (unlimited by default).  A warning is printed when either limit is reached.
+// A limit of 0 is no limit.
+//
+// Normally every file other than a directory that matches a pattern is
+// watched.  The --only-type=f flag only watches regular files while
+// --only-type=d only watches directories, which change when entries are added
+// to or removed from them.  Files larger than --max-file-size, such as core
+// dumps or databases that happen to match a broad pattern, are not watched.
+// The size may be followed by K, M, or G.
+//
+// The --watch-self flag causes autocmd to restart itself, with the same
+// arguments, when its binary changes, which is useful when working on autocmd.
+// The --watch-tool flag, which may be repeated, names a program, such as a
+// locally built compiler, that causes all sets to run when its binary changes.
+// A binary must remain unchanged for a full check before autocmd acts on it.
+//
+// The --after-pid flag, which may be repeated, causes all sets to run when the
+// process PID exits.  The --after flag, which may also be repeated, runs the
+// shell command CMD every 2 seconds and causes all sets to run when its output
+// or exit status changes.  CMD is killed if it runs for more than 10 seconds,
+// and is not run with --dry-run.  For example, to run the tests again when
+// the database container restarts:
+//
+//	autocmd --after='docker inspect -f {{.State.StartedAt}} db' \
+//		'*.go' -- go test ./...
+//
+// The --env-file flag names a file, such as .env, of KEY=VALUE lines that are
+// added to the environment of each command, and of the if=, --after,
+// --healthcheck, --bell-cmd, and --on-timeout commands.  Blank lines and lines
+// starting with # are ignored, a line may start with export, and a value may
+// be quoted.  The file is watched: when it changes it is read again and all
+// sets run, restarting any command that is running.
+//
+// The --pre flag runs the shell command CMD before each command, in the same
+// way as the command, e.g., to warm a build cache or check for forgotten
+// stashes.  If it fails a warning is printed and the command runs anyway,
+// unless --pre-fatal is specified, in which case the run fails.  With
+// --per-file it runs once, before any of the commands.
+//
+// The --max-load flag delays starting commands while the one minute load
+// average is above N, e.g., while something else is building.  The
+// --on-battery flag says what to do with commands while a laptop is running
+// on battery: run them (the default), delay them until it is plugged in, or
+// skip them.  Delayed commands run once the condition clears.
+//
+// The --bell flag rings the terminal bell when a command fails, even with
+// --silent.  The --bell-cmd flag specifies a shell command, such as one that
+// plays a sound, to run instead.  Commands killed by autocmd do not count as
+// failing.
+//
+// When --clear is specified, autocmd rewrites what it has written since the
+// terminal was cleared, such as which command is running, when the terminal
+// is resized.  The output of commands is left as is.
+//
+// The --lazy-clear flag is like --clear, except the display is not cleared
+// until the new command first writes output, or completes.  The output of the
+// previous run remains visible while a slow command gets going.  This
+// requires the output of commands to pass through autocmd, so commands do
+// not see the terminal as their standard output, but it also lets autocmd
+// clear and redraw all of the output when the terminal is resized.
+//
+// The --title flag displays the status in the terminal's title (and the tmux
+// window name when running in tmux), e.g., "autocmd: running go test…",
+// "autocmd: PASS 12:03", or "autocmd: FAIL 12:04".
+//
+// The --status-file flag causes autocmd to maintain a file containing a
+// single line describing its status, for use by editor status lines:
+//
+//	state=fail exit=1 time=2024-01-02T15:04:05-07:00 set=build
+//
+// The state is one of idle, running, pass, fail, or killed.  The exit code is
+// that of the most recently completed command, or - if none has completed.
+//
+// The --webhook flag causes the result of each run to be posted, as JSON, to
+// the specified URL.  For example:
+//
+//	{
+//	  "set": "build",
+//	  "command": ["go", "build"],
+//	  "files": ["* main.go"],
+//	  "start": "2024-01-02T15:04:05.999-07:00",
+//	  "duration": 1.25,
+//	  "exit_code": 1,
+//	  "error": "exit status 1"
+//	}
+//
+// Secrets are redacted, replaced by ***, in autocmd's messages, the
+// --log-file, the --history-file, and webhooks.  A secret is the value of an
+// environment variable, including those in the --env-file, whose name
+// contains TOKEN, SECRET, or PASSWORD, or the value of a NAME=VALUE word, such
+// as --api-token=VALUE, whose name does.  The --redact flag, which may be
+// repeated, adds to these names.  Names are not case sensitive and values
+// shorter than 4 characters are not redacted.  The output of commands is
+// not redacted.
+//
+// The files are those reported by --dry-run.  The exit code is -1 if the
+// command was killed by a signal.  Killed is set to true if autocmd killed
+// the command.
+//
+// The --user flag causes commands to be run as the specified user, given
+// either by name or numeric ID, rather than the user running autocmd.  This
+// permits autocmd to run as root, e.g., to watch files in /etc, while the
+// commands it runs do not.  The HOME, USER, and LOGNAME environment variables
+// of the commands are set to match.  Hooks, such as --on-timeout and
+// --bell-cmd, are still run as the user running autocmd.
+//
+// The --mem-limit and --cpu-limit flags set resource limits on the commands
+// that are run.  The --mem-limit flag limits the virtual memory of each
+// process (e.g., --mem-limit=4G) and the --cpu-limit flag limits the CPU time
+// each process may use (e.g., --cpu-limit=5m).  The kernel kills, or denies
+// memory to, a process exceeding its limit, so a runaway command is stopped
+// long before --timeout would stop it.  The limits are inherited by every
+// process a command starts, but apply to each process separately.
+// --mem-limit is not supported on OpenBSD, which cannot limit virtual memory.
+//
+// The --nice flag runs commands at a lower priority, as if by nice -n N, so
+// builds do not make interactive programs stutter.  The --ionice flag
+// additionally runs commands in the idle I/O scheduling class using ionice(1),
+// which is only available on Linux.  The priority of a process is inherited
+// by every process it starts.
+//
+// The --sandbox flag runs commands, on Linux, in new mount and network
+// namespaces using bwrap(1) from bubblewrap, as a guard against a command
+// writing outside of the project.  The file system is read-only except for
+// the current directory and any paths given by --sandbox-write, which may be
+// repeated.  /tmp is an empty private directory and there is no network.
+// Tools that keep a cache, e.g., go build, need it to be writable:
+//
+//	autocmd --sandbox --sandbox-write ~/.cache/go-build --go go generate ./...
+//
+// The --remote flag watches a directory on another host rather than the local
+// file system, e.g., --remote=user@buildbox:src/project.  Patterns are
+// relative to the remote directory.  The commands are still run locally, and
+// the config files are still read and watched locally.  autocmd uses ssh to
+// run a helper on the remote host that lists the files in the directory every
+// --frequency (rounded up to a second).  The remote host must have GNU find
+// and ssh must not require a password.
+//
+// The --watchman flag asks a running Watchman daemon which files have changed
+// rather than reading directories, which is much cheaper in a large tree
+// that Watchman already watches.  Watchman's own ignores, e.g., from
+// .watchmanconfig, apply along with those of autocmd.  Only files within the
+// current directory can be watched this way.  The socket is found with
+// watchman get-sockname unless $WATCHMAN_SOCK is set.
+//
+// How files are found is determined by their source.  Normally the local
+// file system is polled.  The --source flag, which may be repeated, names the
+// source of the files in a directory as [DIR=]NAME[:ARG], where DIR defaults
+// to the current directory.  Each file is found by the source of the longest
+// DIR containing it, even when a pattern, such as .../*.go, spans the
+// directories of several sources.  The sources are
+// poll, the default, remote:[USER@]HOST:DIR, as used by --remote, watchman,
+// as used by --watchman, and git, which watches only the files git knows
+// about, i.e., not those ignored by .gitignore.  For example, to poll a
+// network mount while asking watchman about everything else:
+//
+//	autocmd --source=watchman --source=mnt/nfs=poll '.../*.go' -- go build
+//
+// The --saves flag is for editors that can report when they save a file.
+// Rather than looking for changed files, autocmd reads the names of saved
+// files, one per line, from the standard input (--saves=-) or from
+// connections to a unix socket it listens on (--saves=PATH).  Each set with a
+// pattern matching a saved file runs as if the file had changed, or had been
+// removed if it no longer exists.  This avoids waiting for a poll to notice
+// the change, which can be slow on some file systems, e.g., the bind mounts
+// of containers:
+//
+//	echo main.go | nc -U /tmp/saves.sock
+//
+// The --sync flag copies the changed files to a destination directory, either
+// local or of the form [USER@]HOST:DIR, using rsync(1) before running the
+// command.  Files that have been removed are removed from the destination.
+// The first run copies every watched file.  If the copy fails the command is
+// not run.  Normally the command is run locally.  The --sync-exec flag runs
+// the command in the destination directory instead, using ssh if the
+// destination is on another host.  When such a command is killed its
+// processes on the other host are killed as well.  rsync itself is always
+// run as the user running autocmd, without --mem-limit, --user, and the like.
+//
+// The --docker flag runs commands in a running container using docker exec,
+// e.g., --docker=devbox.  The container must have /bin/sh.  Commands are run
+// in the container's working directory.  When a command is killed, e.g.,
+// because a file changed or it timed out, the processes in the container are
+// killed as well.  The --mem-limit, --cpu-limit, --nice, and --ionice flags
+// apply to the local docker command, not the processes in the container.
+//
+// The --kubectl flag is like --docker but runs commands in a Kubernetes pod
+// using kubectl exec, e.g., --kubectl=web-7d9f:app.  The optional container
+// name follows the colon.  The pod may be given as TYPE/NAME, e.g.,
+// --kubectl=deployment/web, to have kubectl pick a current pod of the
+// deployment.  Each command is a new exec, so a command run after the pod has
+// been restarted runs in the new pod.
+//
+// The --git-head flag watches the HEAD of the git repository containing the
+// current directory.  When HEAD changes, e.g., a different branch is checked
+// out or new commits are pulled, all commands are run even if no watched file
+// appears to have changed.  autocmd does not look for changed files while git
+// is changing the working tree so the files changed by a checkout are seen as
+// a single change.
+//
+// The --git-diff flag restricts the files that trigger commands to those
+// that differ from a git ref, e.g., --git-diff=origin/main, along with any
+// untracked files.  This ignores churn in generated files that are checked
+// in.  The {file} and {files} placeholders refer to all the watched files
+// that differ from the ref, not just those that changed.
+//
+// The --version flag prints the version of autocmd and how it was built.  The
+// --help-json flag writes a description of the flags, set options,
+// placeholders, and subcommands of autocmd as JSON, for use by tools that
+// wrap autocmd.
+//
+// The --completion flag writes a completion script for bash, zsh, or fish.
+// The script completes flags, set options, the names of the sets in the
+// config file, and commands following --:
+//
+//	source <(autocmd --completion=bash)
 //
 // # CONFIG
 //
+// The init subcommand writes a config file, .autocmd unless a path is given,
+// for the project in the current directory.  It looks for go.mod,
+// package.json, Cargo.toml, and a Makefile, and asks which of the sets it
+// proposes to use and what their commands should be:
+//
+//	autocmd init
+//
 // A config file, specified by --config, can be used to alter the patterns
 // looked for by --go.  An example configuration:
 //
@@ -35,58 +553,521 @@
 //	go: .../*.sdl
 //	go: BUILD
 //
-// If --config is not specified then the config will be read from the .autocmd
-// file in the current directory, if it exists.  If not the default config will
-// be used if it exists.
+// If --config is not specified the config is layered: the default config,
+// $XDG_CONFIG_HOME/autocmd/config ($HOME/.config/autocmd/config if
+// $XDG_CONFIG_HOME is not set, or $HOME/.config/autocmd if that is where an
+// existing default config is), is read first and then the .autocmd file in the
+// current directory, either of which may be missing.  The go lines and the
+// timeout of .autocmd replace those of the default config while its
+// excludes, sets, and matchers are added to them.  Flags, such as --timeout
+// and --exclude, are applied on top.  The --show-config flag writes the
+// resulting config, including the sets given on the command line, and exits.
+//
+// Lines following a section header naming an operating system, such as
+// [darwin], [linux], or [windows], are only used when running on that system.
+// The [all] section header returns to lines used on every system:
+//
+//	go: .../*.go
+//	[darwin]
+//	go: .../*.m
+//	[all]
+//	include: personal.autocmd
+//
+// The include directive reads another config file as if it were part of this
+// one.  A relative path is relative to the directory of the including file.
+// An included file that does not exist is ignored, which makes it suitable for
+// optional personal overrides of a shared config.
+//
+// The config file may also specify additional sets of commands to run, files
+// to exclude, and the timeout for commands.  A set line has the same form as
+// the command line, with quotes used to group words:
 //
-// The config file is silently added to the list of files to check and will be
-// reread if it changes.
+//	set: grammar.y -- goyacc -o grammar.go grammar.y
+//	set: .../*.proto -- sh -c "protoc --go_out=. *.proto"
+//	exclude: .../testdata/...
+//	timeout: 5m
+//
+// A matcher line defines a problem matcher, see --summarize.
+//
+// A quiet-hours line gives the hours, in local time, during which commands
+// are not run, which may span midnight.  Changes seen during quiet hours are
+// run when they are over.  The names of sets that still run, such as a quick
+// lint, may follow the hours:
+//
+//	quiet-hours: 22:00-07:00 lint
+//
+// A config file may start with a version line, which is currently 2:
+//
+//	version: 2
+//
+// A config with a version line is checked strictly: an unknown directive is
+// an error and the config is not used, as is a directive or set option that
+// is newer than the version, e.g., quiet-hours in a version 1 config.  A
+// config without one is assumed to be from before the format was versioned
+// and unknown directives are only warned about.  Running
+//
+//	autocmd config migrate [PATH]
+//
+// upgrades the config, .autocmd or --config by default, to the current
+// version.  Directives the older version ignored are commented out.  The
+// original file is kept with .bak appended to its name.
+//
+// If the config file specifies sets then autocmd may be run without any
+// arguments.  The --timeout flag overrides the config's timeout.
+//
+// The config file, and any files it includes, are silently added to the list
+// of files to check and the config will be reread if any of them change.
+// Rereading the config adds and removes sets and updates the excludes and
+// timeout.  Sets that did not change do not run again due to a reread.
 //
 // Using --config= will prevent any configuration file from being read.
+//
+// The --dry-run (-n) flag causes autocmd to watch for changes as normal, but
+// rather than running a command it prints the command that would have been run
+// along with the files that triggered it.  Each file is preceded by + if it
+// was added, * if it changed, and - if it was removed, and followed by the set
+// and the pattern that matched it.  --verbose reports changed files the same
+// way, e.g., for the set named build in the config file:
+//
+//	$ autocmd -v
+//	* cmd/foo/main.go (set build, pattern .../*.go)
+//
+// The --verbose (-v) flag may be repeated for more detail.  -v reports only
+// the files that changed.  -vv also reports, with =, each file that was
+// checked and had not changed.  -vvv also reports how many files each
+// pattern matched and how long each pattern and each pass took.
+//
+// Messages are logged with log/slog.  Verbose messages are logged at the
+// debug level, problems that do not stop autocmd, e.g., a failed webhook, at
+// the warn level, and everything else at the info level.  --log-level
+// overrides the level set by --verbose and --silent.  --log-format selects
+// console (the default, just the messages), text (slog's key=value format),
+// or json.  --log-file appends the messages to a file rather than writing
+// them to the standard output:
+//
+//	autocmd --log-format=json --log-file=autocmd.log --go go test
+//
+// Commands write their output through autocmd a line at a time, so lines
+// written to the standard output and standard error, or by commands running
+// at the same time, are not mixed together.  A partial line is held until it
+// is completed or the command exits, which delays prompts and progress
+// meters that do not end their lines.  The --passthrough flag lets commands
+// write directly to autocmd's output instead.
+//
+// While a command runs, a status line at the bottom of the terminal shows a
+// spinner and how long the command has been running, so a long silent build
+// does not look hung.  The status line is erased whenever a line of output is
+// written.  It is only shown when autocmd's output is to a terminal, and not
+// with --passthrough, --keep-alive, --tui, or --silent.  --no-spinner turns it
+// off.
+//
+// Once a set has run, the status line also shows how long the set usually
+// takes, the median of its last 10 runs in the history, e.g., "~40s based on
+// last 10 runs".  Whether or not the status line is shown, autocmd warns when
+// a run of a set with at least 3 runs in the history takes more than twice as
+// long as usual, long before a --timeout would be reached.
+//
+// When several commands run at once, e.g., with --keep-alive or --per-file,
+// their output is hard to tell apart.  The --prefix flag prefixes each line
+// a command writes with the name, or number, of its set, in a color of its
+// own if the output is to a terminal and $NO_COLOR is not set:
+//
+//	build | ok  	example.com/foo	0.012s
+//	docs  | wrote out/index.html
+//
+// The --summarize flag prints a summary of the failures in the output of
+// each run once it completes.  --summarize=go-test summarizes the output of
+// go test, with or without -json, listing each package that failed, its
+// failed tests with the first line each logged, and the first errors of
+// packages that did not build:
+//
+//	FAIL example.com/foo
+//		TestParse: parse_test.go:42: got 1, want 2
+//	FAIL example.com/bar [build failed]
+//		bar/bar.go:3:13: undefined: x
+//	1 tests failed in 2 packages
+//
+// Problem matchers find the errors and warnings reported by any tool.  A
+// matcher is a regular expression that is matched against each line of
+// output, without leading white space, with named groups for the file, line,
+// and optionally the col(umn), severity, and message.  The go, gcc, and tsc
+// matchers are built in and more may be defined in the config:
+//
+//	matcher: lint ^(?P<file>[^:]+):(?P<line>\d+): (?P<message>.*)$
+//
+// --summarize=NAME lists the problems found by the matcher NAME and
+// --summarize=problems those found by any matcher.  A problem found more
+// than once is only listed once.
+//
+// The --quickfix flag writes the problems found in the output of each run to
+// a file, one per line, in the form FILE:LINE:COL: MESSAGE.  The problems are
+// found by the matcher named by --summarize, if any, otherwise by all the
+// matchers.  The file is rewritten after every run, so it always lists the
+// latest problems, ready for :cfile in vim or compilation mode in emacs:
+//
+//	autocmd --quickfix=errors.err --go go build ./...
+//
+// The --coverage-file flag keeps the coverage of each package, as reported
+// by go test -cover, with or without -json, in a file.  Each line of the
+// file is a package and its coverage in percent, e.g., "example.com/foo
+// 85.7".  The file is updated after each run that reports coverage, packages
+// that were not tested keep their previous coverage:
+//
+//	autocmd --coverage-file=.coverage --go go test -cover ./...
+//
+// The --tui flag shows a full screen dashboard rather than scrolling output.
+// The top of the screen lists each set with the state of its last run (idle,
+// running, pass, fail, killed, or unhealthy) and how long it took.  The rest
+// of the screen is divided into panes, one for the output of autocmd itself
+// and one for the output of the commands of each set.  With --clear a set's
+// pane is cleared each time it runs.  Pressing r runs all the sets, p pauses
+// or resumes autocmd, and q quits, just as sending SIGUSR1, SIGUSR2, or
+// SIGTERM would.  Tab focuses the next pane and z shows just the focused
+// pane, or all of them again.  The focused pane is scrolled with j and k, or
+// the arrow keys, and page up and page down.  G returns to following its
+// output.
+//
+// The --timestamps flag prefixes each line autocmd writes with the time.  The
+// format is a Go time layout, e.g., --timestamps=15:04:05.000, and defaults
+// to "2006-01-02 15:04:05" when given as just --timestamps.  With
+// --timestamp-output the lines the commands write are prefixed as well.
+//
+// The --projects flag runs autocmd in each of several projects from a single
+// terminal.  Each line of the named file is a project directory, relative to
+// the directory of the file, optionally followed by more arguments for the
+// autocmd run in that directory.  Each autocmd uses the config in its
+// directory and is run with the other flags given, less --socket so each has
+// its own control socket.  Paths given to flags, e.g., --config, --env-file,
+// and --status-file, are relative to the current directory, as usual, and
+// each autocmd keeps its own --state in $XDG_STATE_HOME, as with --state=@.
+// Each line they write is prefixed with the name of the project.  An autocmd
+// that fails is restarted after 5 seconds:
+//
+//	# services.autocmd
+//	auth
+//	billing --timeout=10m
+//	frontend '*.ts' -- npm run build
+//
+//	autocmd --timestamps --projects services.autocmd
+//
+// # CONTROL
+//
+// A running autocmd listens for requests on a control socket.  By default the
+// socket is named after the current directory and placed in $XDG_RUNTIME_DIR,
+// or the temporary directory, so each project has its own socket.  The
+// --socket flag specifies a different path.
+//
+// Only one autocmd runs in a directory at a time, as two autocmds watching
+// the same files race each other to run the same commands.  An autocmd
+// started without patterns in a directory where one is already running
+// attaches to it instead, as does
+//
+//	autocmd attach
+//
+// An attached autocmd writes what the running autocmd writes, its messages
+// and the output of its commands, until it exits.  Entering a line triggers
+// the set it names, or the first set if it is empty, as --trigger does.  The
+// output of commands is not seen with --passthrough.  Lines are dropped if
+// the attached autocmd cannot keep up.  An autocmd started with patterns
+// exits with an error rather than attaching.  The --force flag runs a second
+// autocmd in either case.  The lock is a file next to the control socket, so
+// autocmds with different --socket flags do not exclude each other.
+//
+// Running
+//
+//	autocmd --trigger [SET]
+//
+// in the same directory causes the running autocmd to run the commands of set
+// SET, as if one of its files had changed.  SET is either the name or the
+// number of the set.  The first set is used if SET is not specified.  This is
+// useful for checking a command line, its environment, and --clear without
+// touching any files.
+//
+// Running
+//
+//	autocmd history
+//
+// in the same directory lists the last --history (100 by default) runs of the
+// running autocmd: when each started, how it ended, how long it took, its
+// command, and the files that triggered it.  The --history-file flag also
+// appends each run, as a line of JSON, to a file, which is reloaded when
+// autocmd starts and read by autocmd history when autocmd is not running.
+//
+// Running
+//
+//	autocmd files
+//
+// in the same directory lists how many files each set of the running
+// autocmd is watching and roughly how much memory that takes.  autocmd also
+// warns when a set watches 100,000 files or more, or suddenly watches ten
+// times as many files as it did, which usually means a build or dependency
+// directory appeared under one of its patterns.
+//
+// Running autocmd with install-service as its first argument after the
+// flags, e.g.,
+//
+//	autocmd --state=@ install-service '*.proto' -- make generate
+//
+// installs a service that runs the same command, without install-service,
+// in the current directory when the user logs in and restarts it if it
+// fails.  On Linux this is a systemd user unit, in
+// $XDG_CONFIG_HOME/systemd/user, which is enabled and started.  On macOS it is
+// a launchd agent in ~/Library/LaunchAgents, which is loaded.  With --dry-run
+// (-n) the service is written to the standard output instead.
+//
+// # SIGNALS
+//
+// Sending SIGUSR1 to autocmd causes all sets to run, as if their files had
+// changed.  Sending SIGUSR2 pauses autocmd, it stops checking for changes and
+// running commands, or resumes it if it is paused.  Changes made while paused
+// are acted on when resumed.  A running command is not affected.  For example,
+// from a git hook:
+//
+//	pkill -USR1 -x autocmd
+//
+// An interrupt (^C) kills the running command, a second interrupt exits
+// autocmd.  Any other terminating signal kills the running command and exits.
+//
+// # CHECKING
+//
+// The --check flag causes autocmd to report the config files read and, for
+// each set, how many files each pattern currently matches.  Patterns that
+// match no files are flagged and cause autocmd to exit with a status of 1.
+// No commands are run.
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"sort"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/pborman/getopt/v2"
 	"github.com/pborman/options"
-	"github.com/pborman/ps"
 )
 
 var flags = struct {
-	Git       bool          `getopt:"--git do not ignore .git directories exapnded by ..."`
-	Go        bool          `getopt:"--go shorthand for '--clear ./.../*.go --'"`
-	Verbose   bool          `getopt:"--verbose -v be verbose"`
-	Quiet     bool          `getopt:"--silent -s be very very quiet"`
-	Timeout   time.Duration `getopt:"--timeout=DUR -t set timeout for commands"`
-	Clear     bool          `getopt:"--clear -c clear display before executing a command"`
-	Wait      bool          `getopt:"--wait wait for first change"`
-	Frequency time.Duration `getopt:"--frequency=DUR -f set time to delay between checks"`
-	Config    string        `getopt:"--config=PATH path to config file to load"`
+	Git                bool          `getopt:"--git do not ignore .git directories exapnded by ..."`
+	GitHead            bool          `getopt:"--git-head run all commands when the git HEAD changes"`
+	GitDiff            string        `getopt:"--git-diff=REF only files that differ from git REF trigger commands"`
+	Go                 bool          `getopt:"--go shorthand for '--clear ./.../*.go --'"`
+	Verbose            bool          `getopt:"--verbose -v be verbose, repeat for more detail (-vv, -vvv)"`
+	Quiet              bool          `getopt:"--silent -s be very very quiet"`
+	Timestamps         string        `getopt:"--timestamps=FORMAT prefix each line autocmd writes with the time in FORMAT, a Go time layout"`
+	Passthrough        bool          `getopt:"--passthrough let commands write directly to autocmd's output rather than a line at a time"`
+	Summarize          string        `getopt:"--summarize=KIND print a summary of the failures in the output of each run (go-test, problems, or a matcher)"`
+	Quickfix           string        `getopt:"--quickfix=PATH write the problems found in the output of each run to PATH"`
+	CoverageFile       string        `getopt:"--coverage-file=PATH keep the coverage of each package reported by go test -cover in PATH"`
+	Prefix             bool          `getopt:"--prefix prefix each line commands write with the name of their set"`
+	TimestampOutput    bool          `getopt:"--timestamp-output with --timestamps, also prefix each line commands write"`
+	LogLevel           string        `getopt:"--log-level=LEVEL only log messages at LEVEL (debug, info, warn, or error) or above"`
+	LogFormat          string        `getopt:"--log-format=FORMAT write log messages as console, text, or json"`
+	LogFile            string        `getopt:"--log-file=PATH append log messages to PATH rather than the standard output (@ for the default)"`
+	Timeout            time.Duration `getopt:"--timeout=DUR -t set timeout for commands"`
+	OnTimeout          string        `getopt:"--on-timeout=CMD shell command to run when a command times out"`
+	TimeoutAction      string        `getopt:"--timeout-action=ACTION what to do when a command times out (kill or warn)"`
+	NoSpinner          bool          `getopt:"--no-spinner do not show a status line while commands run"`
+	TUI                bool          `getopt:"--tui show a full screen dashboard of the sets and their output"`
+	Clear              bool          `getopt:"--clear -c clear display before executing a command"`
+	LazyClear          bool          `getopt:"--lazy-clear like --clear, but wait for the command's first output to clear"`
+	Wait               bool          `getopt:"--wait wait for first change"`
+	State              string        `getopt:"--state=PATH remember what has been seen in PATH across restarts (@ for the default)"`
+	RunMissed          bool          `getopt:"--run-missed with --state, run sets whose files changed while autocmd was not running"`
+	Frequency          time.Duration `getopt:"--frequency=DUR -f set time to delay between checks"`
+	Config             string        `getopt:"--config=PATH path to config file to load"`
+	Exclude            []string      `getopt:"--exclude=PATTERN never watch files matching PATTERN"`
+	Root               []string      `getopt:"--root=DIR watch patterns relative to DIR (may be repeated)"`
+	RootDetect         bool          `getopt:"--root-detect run in the closest parent directory with a .autocmd, go.mod, or .git"`
+	DirWait            time.Duration `getopt:"--dir-wait=DUR exit if the current or a --root directory is missing for DUR (default wait forever)"`
+	LoopRate           int           `getopt:"--loop-rate=N pause a set that runs more than N times a minute for --loop-time (0 to never pause)"`
+	LoopTime           time.Duration `getopt:"--loop-time=DUR see --loop-rate"`
+	NoExpand           bool          `getopt:"--no-expand do not expand $VAR and ~ in patterns"`
+	IgnoreOwnOutput    bool          `getopt:"--ignore-own-output ignore files modified while a command runs"`
+	Output             []string      `getopt:"--output=PATTERN ignore changes to files matching PATTERN made while a command runs"`
+	ShowConfig         bool          `getopt:"--show-config write the effective config and exit"`
+	Check              bool          `getopt:"--check report what each pattern matches and exit"`
+	Version            bool          `getopt:"--version print the version of autocmd and exit"`
+	HelpJSON           bool          `getopt:"--help-json describe the flags of autocmd as JSON and exit"`
+	Completion         string        `getopt:"--completion=SHELL write a completion script for SHELL (bash, zsh, or fish) and exit"`
+	DryRun             bool          `getopt:"--dry-run -n print commands that would run but do not run them"`
+	Trigger            bool          `getopt:"--trigger run set SET of the running autocmd"`
+	Projects           string        `getopt:"--projects=FILE run autocmd in each of the project directories listed in FILE"`
+	Force              bool          `getopt:"--force run even if autocmd is already running in the current directory"`
+	Socket             string        `getopt:"--socket=PATH path of the control socket"`
+	ScanJobs           int           `getopt:"--scan-jobs=N number of files to stat concurrently"`
+	Rescan             time.Duration `getopt:"--rescan=DUR how often to rewalk directories expanded by ..."`
+	MaxDepth           int           `getopt:"--max-depth=N do not expand ... more than N directories deep"`
+	MaxFiles           int           `getopt:"--max-files=N stop expanding ... after visiting N files"`
+	IgnoreDir          []string      `getopt:"--ignore-dir=NAME do not descend into directories named NAME when expanding ..."`
+	NoIgnore           bool          `getopt:"--no-default-ignores do not ignore the default directories when expanding ..."`
+	MaxFileSize        string        `getopt:"--max-file-size=SIZE ignore files larger than SIZE (e.g., 100M)"`
+	Stat               string        `getopt:"--stat=MODE how files are compared (basic, ctime, or full)"`
+	MtimeGranularity   time.Duration `getopt:"--mtime-granularity=DUR hash files modified within DUR of being checked"`
+	NetworkFS          bool          `getopt:"--network-fs tune change detection for network file systems"`
+	Profile            bool          `getopt:"--profile report how long each pass, and each pattern, takes"`
+	BenchPasses        int           `getopt:"--bench-passes=N number of passes made by autocmd bench"`
+	OnlyType           string        `getopt:"--only-type=TYPE only watch regular files (f) or directories (d)"`
+	WatchSelf          bool          `getopt:"--watch-self restart autocmd if its binary changes"`
+	WatchTool          []string      `getopt:"--watch-tool=PROG run all sets if the binary PROG changes"`
+	AfterPid           []string      `getopt:"--after-pid=PID run all sets when process PID exits"`
+	URL                []string      `getopt:"--url=URL run all sets when the resource at URL changes"`
+	After              []string      `getopt:"--after=CMD run all sets when the output of the shell command CMD changes"`
+	MaxLoad            float64       `getopt:"--max-load=N delay commands while the load average is above N"`
+	OnBattery          string        `getopt:"--on-battery=POLICY run, delay, or skip commands while on battery"`
+	Pre                string        `getopt:"--pre=CMD run the shell command CMD before each command"`
+	PreFatal           bool          `getopt:"--pre-fatal do not run the command if the --pre command fails"`
+	EnvFile            string        `getopt:"--env-file=PATH add the variables in PATH to the environment of commands"`
+	PerFile            bool          `getopt:"--per-file run the command once for each changed file"`
+	Jobs               int           `getopt:"--jobs=N run up to N commands at once with --per-file"`
+	KeepAlive          bool          `getopt:"--keep-alive start commands once and write changed files to their standard input"`
+	Listen             string        `getopt:"--listen=ADDR listen on ADDR and pass the socket to commands"`
+	Proxy              string        `getopt:"--proxy=LISTEN->TARGET proxy requests to TARGET, holding them while it restarts"`
+	LiveReload         string        `getopt:"--livereload=ADDR tell browsers to reload after a command succeeds (e.g., :35729)"`
+	Healthcheck        string        `getopt:"--healthcheck=CMD|URL check a started server is healthy with CMD or URL"`
+	HealthcheckTimeout time.Duration `getopt:"--healthcheck-timeout=DUR how long a server has to become healthy"`
+	Bell               bool          `getopt:"--bell ring the terminal bell when a command fails"`
+	BellCmd            string        `getopt:"--bell-cmd=CMD shell command to run, instead of ringing the bell, when a command fails"`
+	Webhook            string        `getopt:"--webhook=URL post the result of each run to URL"`
+	Redact             []string      `getopt:"--redact=NAME also redact the values of variables whose names contain NAME"`
+	Title              bool          `getopt:"--title show the status in the terminal title"`
+	History            int           `getopt:"--history=N remember the last N runs (see autocmd history)"`
+	HistoryFile        string        `getopt:"--history-file=PATH also append each run to PATH (@ for the default)"`
+	StatusFile         string        `getopt:"--status-file=PATH keep a one line status in PATH"`
+	User               string        `getopt:"--user=NAME run commands as user NAME"`
+	MemLimit           string        `getopt:"--mem-limit=SIZE limit the virtual memory of each command process to SIZE (e.g., 4G)"`
+	CPULimit           time.Duration `getopt:"--cpu-limit=DUR limit the CPU time of each command process to DUR"`
+	Nice               int           `getopt:"--nice=N run commands with a niceness of N"`
+	IONice             bool          `getopt:"--ionice run commands in the idle I/O scheduling class (Linux)"`
+	Sandbox            bool          `getopt:"--sandbox run commands with a read-only file system and no network (Linux)"`
+	SandboxWrite       []string      `getopt:"--sandbox-write=PATH let sandboxed commands write to PATH (may be repeated)"`
+	Saves              string        `getopt:"--saves=SOURCE run sets when files are reported saved on SOURCE (- or a socket) rather than scanning"`
+	Source             []string      `getopt:"--source=[DIR=]NAME[:ARG] find the files in DIR with the named source (may be repeated)"`
+	Watchman           bool          `getopt:"--watchman find changed files with a running watchman"`
+	Remote             string        `getopt:"--remote=[USER@]HOST:DIR watch DIR on HOST over ssh"`
+	Sync               string        `getopt:"--sync=DEST rsync changed files to DEST before running the command"`
+	SyncExec           bool          `getopt:"--sync-exec run the command in the --sync destination"`
+	Docker             string        `getopt:"--docker=CONTAINER run commands in CONTAINER with docker exec"`
+	Kubectl            string        `getopt:"--kubectl=POD[:CONTAINER] run commands in a Kubernetes pod with kubectl exec"`
 }{
-	Timeout:   time.Hour,
-	Frequency: time.Second / 2,
-	Config:    os.ExpandEnv("$HOME/.config/autocmd"),
+	Timeout:            time.Hour,
+	TimeoutAction:      "kill",
+	Frequency:          time.Second / 2,
+	OnBattery:          "run",
+	ScanJobs:           8,
+	Rescan:             time.Minute,
+	MaxFiles:           100000,
+	Jobs:               1,
+	Stat:               "basic",
+	BenchPasses:        10,
+	History:            100,
+	LoopRate:           10,
+	LoopTime:           2 * time.Minute,
+	HealthcheckTimeout: 30 * time.Second,
+	Config:             defaultConfig(),
+}
+
+// verbosity is the level of verbosity: the number of times --verbose (-v)
+// was specified.  At level 1 files that changed are reported, at level 2 the
+// files that did not change are also reported, and at level 3 how long each
+// pass and pattern took, and how patterns were expanded, are also reported.
+var verbosity int
+
+// countVerbose returns the number of times --verbose or -v appears in args,
+// the command line arguments, including in groups of short flags such as
+// -vv or -cv.
+func countVerbose(args []string) int {
+	takesArg := flagsTakingArg()
+	n := 0
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		switch {
+		case arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-"):
+			return n
+		case strings.HasPrefix(arg, "--"):
+			if arg == "--verbose" {
+				n++
+			}
+			if takesArg[arg] && len(args) > 0 {
+				args = args[1:]
+			}
+		default:
+			for i, c := range arg[1:] {
+				if c == 'v' {
+					n++
+				}
+				if takesArg["-"+string(c)] {
+					if i == len(arg)-2 && len(args) > 0 {
+						args = args[1:]
+					}
+					break
+				}
+			}
+		}
+	}
+	return n
 }
 
-// SameFile returns true if f1 and f2 appear to be the same file.
+// flagsTakingArg returns which flags, as --name or -c, are followed by their
+// argument when it is not given as --name=ARG.
+func flagsTakingArg() map[string]bool {
+	takesArg := map[string]bool{}
+	for _, f := range flagInfos() {
+		takesArg["--"+f.Name] = f.Arg != ""
+		if f.Short != "" {
+			takesArg["-"+f.Short] = f.Arg != ""
+		}
+	}
+	for _, name := range optionalFlags {
+		takesArg["--"+name] = false
+	}
+	return takesArg
+}
+
+// SameFile returns true if f1 and f2 appear to be the same file.  A file
+// whose modification time changes, even to an earlier time, has changed.
 func SameFile(f1, f2 os.FileInfo) bool {
 	// We assume that if a file changes modtime then the contents have
 	// changed, even though they might not have.  A more complete check
 	// would actually look at the contents if the files have the same
 	// size but different mod times.  This would require keeping a hash
 	// of every file we know about.
-	return f1.Size() == f2.Size() && f1.ModTime() == f2.ModTime()
+	if f1.Size() != f2.Size() || !f1.ModTime().Equal(f2.ModTime()) {
+		return false
+	}
+	if flags.Stat == "basic" {
+		return true
+	}
+	// With --stat=ctime or --stat=full we also notice changes to the
+	// inode change time, which also changes when the file is written.
+	if c1, ok := ctime(f1); ok {
+		if c2, ok := ctime(f2); ok && !c1.Equal(c2) {
+			return false
+		}
+	}
+	if flags.Stat != "full" {
+		return true
+	}
+	// With --stat=full we also notice changes to the permissions and
+	// ownership of the file, and a file being replaced by another.
+	if f1.Mode() != f2.Mode() {
+		return false
+	}
+	s1, ok1 := f1.Sys().(*syscall.Stat_t)
+	s2, ok2 := f2.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return true
+	}
+	return s1.Ino == s2.Ino && s1.Dev == s2.Dev && s1.Uid == s2.Uid && s1.Gid == s2.Gid
 }
 
 // Expand expands up to 1 occurrence of "..." in pattern and returns
@@ -114,136 +1095,250 @@ func Expand(pattern string) []string {
 		pre = "."
 	}
 	var paths []string
-	filepath.Walk(pre, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if info == nil || !info.IsDir() {
-			return nil
-		}
-		if !flags.Git && filepath.Base(path) == ".git" {
-			return filepath.SkipDir
-		}
-		paths = append(paths, filepath.Join(path, post))
-		return nil
-	})
+	for _, dir := range walkDirs(pre) {
+		paths = append(paths, filepath.Join(dir, post))
+	}
 	return paths
 }
 
 // MultiBlob returns a map of pathnames to os.FileInfo that match one of the
 // provided patterns.  Each pattern is first expanded by Expand and then
-// filepath.Glob is applied to each expanded pattern.  An error is returned
-// if filepath.Glob returns an error.
+// filepath.Glob, by way of glob, is applied to each expanded pattern.  An
+// error is returned if filepath.Glob returns an error.
 func MultiGlob(patterns []string) (map[string]os.FileInfo, error) {
-	var matches []string
-	for _, p := range patterns {
-		for _, p := range Expand(p) {
-			m, err := filepath.Glob(p)
-			if err != nil {
-				return nil, err
-			}
-			matches = append(matches, m...)
-		}
+	return multiGlob(patterns, nil)
+}
+
+// multiGlob is MultiGlob, but it stores the results in f, after clearing
+// it, rather than allocating a new map.  A new map is allocated if f is nil.
+// The files are found by the ChangeSource of each file, see sourceGlob.
+func multiGlob(patterns []string, f map[string]os.FileInfo) (map[string]os.FileInfo, error) {
+	if f == nil {
+		f = map[string]os.FileInfo{}
 	}
-	sort.Strings(matches)
-	f := make(map[string]os.FileInfo, len(matches))
-	for _, path := range matches {
-		if fi, err := os.Stat(path); err == nil {
-			f[path] = fi
-		}
+	for path := range f {
+		delete(f, path)
+	}
+	if err := sourceGlob(rootPatterns(patterns), f); err != nil {
+		return nil, err
 	}
 	return f, nil
 }
 
-var now = time.Now
+// matchPool and infoPool hold the slices used by multiGlob and statAll so
+// that each pass does not allocate new ones.
+var (
+	matchPool = sync.Pool{New: func() interface{} { return new([]string) }}
+	infoPool  = sync.Pool{New: func() interface{} { return new([]os.FileInfo) }}
+)
 
-type set struct {
-	command  []string
-	patterns []string
-	seen     map[string]os.FileInfo
+// rootPatterns returns patterns relative to each of the --root directories.
+// Absolute patterns are returned as is.
+func rootPatterns(patterns []string) []string {
+	if len(flags.Root) == 0 {
+		return patterns
+	}
+	var rp []string
+	for _, p := range patterns {
+		if filepath.IsAbs(p) {
+			rp = append(rp, p)
+			continue
+		}
+		for _, root := range flags.Root {
+			rp = append(rp, filepath.Join(root, p))
+		}
+	}
+	return rp
 }
 
-func newSet(args []string) *set {
-	var s set
-	s.seen = map[string]os.FileInfo{}
-	for x, arg := range args {
-		if arg == "--" {
-			s.command = args[x+1:]
-			s.patterns = args[:x]
-			break
+// statAll stats each of paths and adds the results to f.  Paths that cannot
+// be stat'ed are skipped.  Up to --scan-jobs paths are stat'ed concurrently.
+func statAll(paths []string, f map[string]os.FileInfo) {
+	jobs := flags.ScanJobs
+	if jobs > len(paths) {
+		jobs = len(paths)
+	}
+	if jobs < 2 {
+		for _, path := range paths {
+			if fi, err := stat(path); err == nil {
+				f[path] = fi
+			}
 		}
+		return
 	}
-
-	if len(s.command) == 0 || len(s.patterns) == 0 {
-		getopt.PrintUsage(os.Stderr)
-		os.Exit(1)
+	ip := infoPool.Get().(*[]os.FileInfo)
+	infos := *ip
+	if cap(infos) < len(paths) {
+		infos = make([]os.FileInfo, len(paths))
+	}
+	infos = infos[:len(paths)]
+	defer func() {
+		for x := range infos {
+			infos[x] = nil
+		}
+		*ip = infos[:0]
+		infoPool.Put(ip)
+	}()
+	var next int64 = -1
+	var wg sync.WaitGroup
+	// A panic in a worker is passed on to our caller, which can recover
+	// from it.
+	var (
+		mu       sync.Mutex
+		panicked interface{}
+	)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					mu.Lock()
+					panicked = v
+					mu.Unlock()
+				}
+				wg.Done()
+			}()
+			for {
+				x := int(atomic.AddInt64(&next, 1))
+				if x >= len(paths) {
+					return
+				}
+				if fi, err := stat(paths[x]); err == nil {
+					infos[x] = fi
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	for x, fi := range infos {
+		if fi != nil {
+			f[paths[x]] = fi
+		}
 	}
-	return &s
 }
 
-var (
-	printf     = fmt.Printf
-	clear      = func() {}
-	vprintf    = func(f string, v ...interface{}) {}
-	vprintf2   = func(f string, v ...interface{}) {}
-	vflush     = func() {} // write out the contents of the vprintf buffer
-	vadd       = func() {} // append the vprintf2 buffer to the vprintf buffer
-	vclear     = func() {} // clear the vprintf2 buffer
-	gopatterns = []string{".../*.go"}
-	goset      *set
-)
-
-var configFile string
-var configStat os.FileInfo
+// maxFileSize is the size of the largest file we watch, as set by
+// --max-file-size.  0 means there is no limit.
+var maxFileSize int64
 
-func checkConfig() {
-	if configFile == "" || goset == nil {
-		return
+// parseSize parses a size such as 1024, 10K, 100M, or 2G.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
 	}
-	f1, err := os.Stat(configFile)
-	if err != nil {
-		return
+	if mult != 1 {
+		s = s[:len(s)-1]
 	}
-	defer func() { configStat = f1 }()
-	if configStat == nil || !SameFile(f1, configStat) {
-		readConfig(configFile)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size: %q", s)
 	}
+	return n * mult, nil
 }
 
-func readConfig(path string) bool {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return false
-	}
-	var patterns []string
-	for _, line := range strings.Split(string(data), "\n") {
-		if line == "" || line[0] == '#' {
-			continue
+// Tracked returns true if fi describes a file we should watch.  Normally
+// directories are not watched.  The --only-type flag restricts watching to
+// only regular files or only directories.  Files larger than --max-file-size
+// are not watched.
+func Tracked(fi os.FileInfo) bool {
+	switch flags.OnlyType {
+	case "f":
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+	case "d":
+		return fi.IsDir()
+	default:
+		if fi.IsDir() {
+			return false
 		}
-		cmd := strings.SplitN(line, ":", 2)
-		switch len(cmd) {
-		// case 1: someday for single word commands
-		case 2:
-			switch strings.TrimSpace(cmd[0]) {
-			case "go":
-				patterns = append(patterns, strings.TrimSpace(cmd[1]))
+	}
+	return maxFileSize == 0 || fi.Size() <= maxFileSize
+}
+
+// Match reports whether path matches pattern.  Each element of pattern is
+// matched against an element of path with filepath.Match, except that the
+// element "..." matches any number of elements (including none).  A pattern
+// that contains no / is matched against the last element of path.
+func Match(pattern, path string) bool {
+	pattern = filepath.Clean(pattern)
+	path = filepath.Clean(path)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}
+	return matchElems(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchElems(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "..." {
+			for i := 0; i <= len(path); i++ {
+				if matchElems(pattern[1:], path[i:]) {
+					return true
+				}
 			}
-		default:
-			fmt.Fprintf(os.Stderr, "Invalid config command: %q", line)
-			continue
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
 		}
+		pattern, path = pattern[1:], path[1:]
 	}
-	if len(patterns) > 0 {
-		gopatterns = patterns
+	return len(path) == 0
+}
+
+// globFiles adds the files in files that match any of patterns to f.
+// Patterns are matched as by filepath.Glob except that the element "..."
+// matches any number of directories.
+func globFiles(files map[string]os.FileInfo, patterns []string, f map[string]os.FileInfo) {
+	var elems [][]string
+	for _, p := range patterns {
+		elems = append(elems, strings.Split(filepath.Clean(p), "/"))
 	}
-	gopatterns = append(gopatterns, path)
-	if goset != nil {
-		goset.patterns = gopatterns
+	for path, fi := range files {
+		pelems := strings.Split(path, "/")
+		for _, e := range elems {
+			if matchElems(e, pelems) {
+				f[path] = fi
+				break
+			}
+		}
 	}
-	configFile = path
-	return true
 }
 
+// Excluded returns true if path matches any of the patterns.
+func Excluded(path string, patterns []string) bool {
+	for _, p := range patterns {
+		if Match(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+var now = time.Now
+
+var (
+	printf     = func(f string, v ...interface{}) { logf(slog.LevelInfo, f, v...) }
+	vprintf    = func(f string, v ...interface{}) { logf(slog.LevelDebug, f, v...) }
+	clear      = func() {}
+	gopatterns = []string{".../*.go"}
+	goset      *set
+)
+
 var intChan = make(chan os.Signal, 1)
 
 func main() {
@@ -251,32 +1346,261 @@ func main() {
 
 	var sets []*set
 
-	patterns := options.RegisterAndParse(&flags)
-	if len(patterns) == 0 {
-		getopt.PrintUsage(os.Stderr)
+	options.Register(&flags)
+	for _, name := range optionalFlags {
+		getopt.Lookup(name).SetOptional()
+	}
+	getopt.Parse()
+	patterns := getopt.Args()
+	if getopt.IsSet("timestamps") && flags.Timestamps == "" {
+		flags.Timestamps = defaultTimestamps
+	}
+	setRedact()
+	if flags.Version {
+		printVersion(os.Stdout)
+		os.Exit(0)
+	}
+	if flags.HelpJSON {
+		if err := helpJSON(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flags.Completion != "" && flags.Completion != "sets" {
+		if err := completion(os.Stdout, flags.Completion); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --completion: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	flags.Root = expandPatterns(flags.Root)
+	if flags.RootDetect {
+		if err := absPaths(); err != nil {
+			fmt.Fprintf(os.Stderr, "--root-detect: %v\n", err)
+			os.Exit(1)
+		}
+		if err := changeToRoot(); err != nil {
+			fmt.Fprintf(os.Stderr, "--root-detect: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := setStatePaths(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	if flags.Config != "" {
-		if getopt.IsSet("config") {
-			if !readConfig(flags.Config) {
-				fmt.Fprintf(os.Stderr, "Could not open %s.\n", flags.Config)
-				os.Exit(1)
-			}
+	// Logging is set up before anything else can log or start a
+	// goroutine that logs.  --verbose and --silent set the default log
+	// level, --log-level overrides them.
+	verbosity = countVerbose(os.Args[1:])
+	if flags.Verbose && verbosity == 0 {
+		verbosity = 1
+	}
+	switch {
+	case flags.Quiet:
+		logLevel.Set(slog.LevelWarn)
+	case verbosity > 0:
+		logLevel.Set(slog.LevelDebug)
+	}
+	if err := setLogging(flags.LogLevel, flags.LogFormat, flags.LogFile); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if logLevel.Level() <= slog.LevelDebug && verbosity == 0 {
+		verbosity = 1
+	}
+	if projectRoot != "" {
+		printf("Running in project root %s\n", projectRoot)
+	}
+	if flags.MaxFileSize != "" {
+		size, err := parseSize(flags.MaxFileSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --max-file-size: %v\n", err)
+			os.Exit(1)
 		}
-		if !readConfig(".autocmd") {
-			readConfig(flags.Config)
+		maxFileSize = size
+	}
+	for _, u := range flags.URL {
+		if err := checkURL(u); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --url: %v\n", err)
+			os.Exit(1)
 		}
 	}
+	switch flags.OnlyType {
+	case "", "f", "d":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --only-type: %q\n", flags.OnlyType)
+		os.Exit(1)
+	}
+	switch flags.OnBattery {
+	case "run", "delay", "skip":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --on-battery: %q\n", flags.OnBattery)
+		os.Exit(1)
+	}
+	setupNetworkFS()
+	switch flags.Stat {
+	case "basic", "ctime", "full":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --stat: %q\n", flags.Stat)
+		os.Exit(1)
+	}
+	switch flags.TimeoutAction {
+	case "kill", "warn":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --timeout-action: %q\n", flags.TimeoutAction)
+		os.Exit(1)
+	}
+	var memLimit int64
+	if flags.MemLimit != "" {
+		size, err := parseSize(flags.MemLimit)
+		if err != nil || size == 0 {
+			fmt.Fprintf(os.Stderr, "Invalid --mem-limit: %q\n", flags.MemLimit)
+			os.Exit(1)
+		}
+		if rlimitAS < 0 {
+			fmt.Fprintf(os.Stderr, "--mem-limit: not supported on %s\n", runtime.GOOS)
+			os.Exit(1)
+		}
+		memLimit = size
+	}
+	if flags.CPULimit < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --cpu-limit: %v\n", flags.CPULimit)
+		os.Exit(1)
+	}
+	setLimits(memLimit, flags.CPULimit)
+	if flags.IONice && runtime.GOOS != "linux" {
+		fmt.Fprintf(os.Stderr, "--ionice: only supported on Linux\n")
+		os.Exit(1)
+	}
+	setPriority(flags.Nice, flags.IONice)
+	if flags.Sandbox {
+		if err := setSandbox(flags.SandboxWrite); err != nil {
+			fmt.Fprintf(os.Stderr, "--sandbox: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.User != "" {
+		if err := setUser(flags.User); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --user: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Projects != "" {
+		if len(patterns) > 0 {
+			fmt.Fprintf(os.Stderr, "--projects does not take patterns or commands\n")
+			os.Exit(1)
+		}
+		args, err := projectArgs(os.Args[1:])
+		if err == nil {
+			err = superviseProjects(flags.Projects, args)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--projects: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(patterns) > 0 && patterns[0] == "install-service" {
+		// The service runs autocmd with the same arguments, less
+		// install-service, which is the first argument after the
+		// flags.
+		flagArgs := os.Args[1 : len(os.Args)-len(patterns)]
+		if err := installService(os.Stdout, flagArgs, patterns[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "install-service: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flags.Trigger {
+		resp, err := sendControl(append([]string{"trigger"}, patterns...)...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(resp)
+		if strings.HasPrefix(resp, "error") {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "history") {
+		if err := showHistory(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "history: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "attach") {
+		if err := attach(os.Stdout, os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "attach: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "files") {
+		resp, err := sendControl("files")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "files: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(resp)
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "config") {
+		if err := configCommand(os.Stdout, patterns[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "config: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "init") {
+		if err := initConfig(os.Stdin, os.Stdout, patterns[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "init: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	flags.Exclude = expandPatterns(flags.Exclude)
+	excludes = flags.Exclude
+	switch {
+	case getopt.IsSet("config") && flags.Config != "":
+		if !readConfig(flags.Config) {
+			fmt.Fprintf(os.Stderr, "Could not read %s.\n", flags.Config)
+			os.Exit(1)
+		}
+	case !getopt.IsSet("config"):
+		readConfig(flags.Config, ".autocmd")
+	}
 
-	if flags.Go {
+	if flags.Completion == "sets" {
+		// Used by the completion scripts.
+		printSetNames(os.Stdout)
+		os.Exit(0)
+	}
+	if isSubcommand(patterns, "bench") {
+		bench(os.Stdout, patterns[1:])
+		os.Exit(0)
+	}
+
+	switch {
+	case len(patterns) == 0:
+		// We may only have sets from the config file.
+		if len(configSets) == 0 || flags.Go {
+			getopt.PrintUsage(os.Stderr)
+			os.Exit(1)
+		}
+	case flags.Go:
 		flags.Clear = true
-		sets = []*set{{
-			command:  patterns,
-			patterns: gopatterns,
-			seen:     map[string]os.FileInfo{},
-		}}
-		goset = sets[0]
-	} else {
+		s, err := newGoSet(patterns)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			getopt.PrintUsage(os.Stderr)
+			os.Exit(1)
+		}
+		goset = s
+		sets = []*set{goset}
+	default:
 		sets = []*set{
 			newSet(patterns),
 		}
@@ -290,230 +1614,475 @@ func main() {
 			}
 		}
 	}
+	cmdSets = sets
+	if err := validateSets(allSets()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if flags.KeepAlive && flags.PerFile {
+		fmt.Fprintf(os.Stderr, "--keep-alive and --per-file are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if flags.Saves != "" && (flags.Watchman || flags.Remote != "" || len(flags.Source) > 0) {
+		fmt.Fprintf(os.Stderr, "--saves cannot be used with --watchman, --remote, or --source\n")
+		os.Exit(1)
+	}
+	if flags.Watchman && flags.Remote != "" {
+		fmt.Fprintf(os.Stderr, "--watchman and --remote are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if len(flags.SandboxWrite) > 0 && !flags.Sandbox {
+		fmt.Fprintf(os.Stderr, "--sandbox-write requires --sandbox\n")
+		os.Exit(1)
+	}
+	if flags.Sandbox && flags.Listen != "" {
+		fmt.Fprintf(os.Stderr, "--sandbox and --listen are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if flags.Summarize != "" && summarizer(flags.Summarize) == nil {
+		fmt.Fprintf(os.Stderr, "Invalid --summarize: %q\n", flags.Summarize)
+		os.Exit(1)
+	}
+	if flags.TUI && flags.Saves == "-" {
+		fmt.Fprintf(os.Stderr, "--tui cannot be used with --saves=-\n")
+		os.Exit(1)
+	}
+	if flags.TimestampOutput && flags.Timestamps == "" {
+		fmt.Fprintf(os.Stderr, "--timestamp-output requires --timestamps\n")
+		os.Exit(1)
+	}
+	if flags.RunMissed && flags.State == "" {
+		fmt.Fprintf(os.Stderr, "--run-missed requires --state\n")
+		os.Exit(1)
+	}
+	if flags.SyncExec && flags.Sync == "" {
+		fmt.Fprintf(os.Stderr, "--sync-exec requires --sync\n")
+		os.Exit(1)
+	}
+	if flags.Docker != "" && flags.Kubectl != "" {
+		fmt.Fprintf(os.Stderr, "--docker and --kubectl are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if flags.Docker != "" || flags.Kubectl != "" {
+		if flags.SyncExec {
+			fmt.Fprintf(os.Stderr, "--sync-exec cannot be used with --docker or --kubectl\n")
+			os.Exit(1)
+		}
+		if err := checkContainer(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if flags.GitHead {
+		if err := watchGitHead(); err != nil {
+			fmt.Fprintf(os.Stderr, "--git-head: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Listen != "" {
+		if err := openListener(flags.Listen); err != nil {
+			fmt.Fprintf(os.Stderr, "--listen: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Proxy != "" {
+		if err := startProxy(flags.Proxy); err != nil {
+			fmt.Fprintf(os.Stderr, "--proxy: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.LiveReload != "" {
+		if err := startLiveReload(flags.LiveReload); err != nil {
+			fmt.Fprintf(os.Stderr, "--livereload: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Remote != "" {
+		if err := addSource("remote:" + flags.Remote); err != nil {
+			fmt.Fprintf(os.Stderr, "--remote: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Watchman {
+		if err := addSource("watchman"); err != nil {
+			fmt.Fprintf(os.Stderr, "--watchman: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, spec := range flags.Source {
+		if err := addSource(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "--source: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flags.Saves != "" {
+		if err := startSaves(flags.Saves); err != nil {
+			fmt.Fprintf(os.Stderr, "--saves: %v\n", err)
+			os.Exit(1)
+		}
+		if !flags.Wait {
+			for _, s := range allSets() {
+				s.forced = true
+			}
+		}
+	}
+
+	if flags.ShowConfig {
+		showConfig(os.Stdout)
+		os.Exit(0)
+	}
+	if flags.Check {
+		if !checkSets(os.Stdout, allSets()) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	var cmd *exec.Cmd
+	var cmd *job
 
 	var endTime time.Time
+	var timedOut bool // the running command has exceeded endTime
 
-	if flags.Quiet {
-		printf = func(f string, v ...interface{}) (int, error) { return 0, nil }
+	if !flags.Force {
+		if err := lock(); err != nil {
+			if len(getopt.Args()) > 0 {
+				// This autocmd was asked to watch its own
+				// patterns, which attaching would ignore.
+				fmt.Fprintf(os.Stderr, "%v, use --force to run another\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "%v, attaching to it (--force runs another)\n", err)
+			if err := attach(os.Stdout, os.Stdin); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 	}
+	listenControl()
+	watchBinaries()
+	loadEnvFile()
+	startTriggers()
+	writeStatus("idle", nil)
 
-	// Verbose functions.  They only have effect when flags.Versose is on.
-	// The vprintf2 buffer is cleared before each pass.  If a pass finds
-	// changes then the vprintf2 buffer is writen to the vprintf buffer.
-
-	if flags.Verbose {
-		var vbuf bytes.Buffer
-		var vbuf2 bytes.Buffer
-		vprintf = func(f string, v ...interface{}) {
-			fmt.Fprintf(&vbuf, f, v...)
-		}
-		vprintf2 = func(f string, v ...interface{}) {
-			fmt.Fprintf(&vbuf2, f, v...)
-		}
-		vclear = func() {
-			vbuf2.Reset()
+	if flags.LazyClear {
+		flags.Clear = true
+	}
+	if flags.Clear {
+		term := &screen{w: os.Stdout, direct: !flags.LazyClear}
+		stdout = term
+		stderr = term
+		clear = term.clear
+		if flags.LazyClear {
+			// The screen must see the output of commands to
+			// know when to clear.
+			cmdStdout = term.output()
+			cmdStderr = cmdStdout
+			clear = term.clearLazily
+			settle = term.settle
 		}
-		vadd = func() {
-			io.Copy(&vbuf, &vbuf2)
+		term.redrawOnResize()
+	}
+	if flags.TUI {
+		if err := startTUI(); err != nil {
+			fatalf("--tui: %v\n", err)
 		}
-		vflush = func() {
-			io.Copy(os.Stdout, &vbuf)
-			vbuf.Reset()
+		defer restoreTerminal()
+	}
+	if flags.TimestampOutput {
+		w := &prefixWriter{w: cmdStdout, prefix: timestamp}
+		cmdStdout = w
+		if cmdStderr != w.w {
+			cmdStderr = &prefixWriter{w: cmdStderr, prefix: timestamp}
+		} else {
+			cmdStderr = w
 		}
 	}
 
-	if flags.Clear {
-		clear = func() {
-			os.Stdout.Write([]byte("\033[H\033[2J\033[3J"))
+	if flags.HistoryFile != "" {
+		if err := loadHistory(); err != nil {
+			fatalf("--history-file: %v\n", err)
+		}
+	}
+	if flags.State != "" {
+		loaded, err := loadState()
+		if err != nil {
+			fatalf("%v\n", err)
+		}
+		if !flags.RunMissed {
+			for _, s := range loaded {
+				s.reset(now())
+			}
 		}
 	}
-
 	if flags.Wait {
-		for _, s := range sets {
-			s.same()
+		for _, s := range allSets() {
+			s.reset(now())
 		}
 		time.Sleep(flags.Frequency)
 	}
 
-	t := time.NewTicker(flags.Frequency)
+	initDirs()
+	interval := checkInterval()
+	t := time.NewTicker(interval)
 	finished := make(chan struct{})
 	close(finished)
 
-	signal.Notify(intChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGTSTP)
+	// running is the set whose command was most recently started, it is
+	// set to nil once the command has finished.  pending are the sets
+	// waiting to run once running has finished, in the order they should
+	// run.
+	var running *set
+	var pending []*set
+
+	signal.Notify(intChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGTSTP, syscall.SIGUSR1, syscall.SIGUSR2)
 	hadInt := false
-	for tick := range t.C {
+	for {
+		// Wake up early if the running command finishes so any sets
+		// waiting on it can run without waiting for the next tick.
+		var done chan struct{}
+		if running != nil {
+			done = finished
+		}
+		var tick time.Time
+		select {
+		case tick = <-t.C:
+		case <-done:
+			tick = now()
+		}
 		select {
 		case sig := <-intChan:
-			if cmd != nil && cmd.Process != nil {
-				pids := append(ps.GetDecendents(cmd.Process.Pid), cmd.Process.Pid)
-				if len(pids) > 0 {
-					printf("Killing interrupted children\n")
-					killall(pids)
-				}
-				cmd.Process.Kill()
-				cmd.Wait()
+			if userSignal(sig) {
+				break
+			}
+			if cmd != nil {
+				printf("Killing interrupted children\n")
+				killGroup(cmd, finished)
 				cmd = nil
 			}
+			stopKeepAlive()
+			running, pending = nil, nil
 			switch sig {
 			case syscall.SIGTSTP:
 				// Force us to run again
-				for _, s := range sets {
+				for _, s := range allSets() {
 					s.seen = map[string]os.FileInfo{}
 					break
 				}
 				hadInt = false
 			case syscall.SIGINT:
 				if hadInt {
-					os.Exit(1)
+					saveState()
+					exit(1)
 				}
 				printf("Press ^C again to quit\n")
 				hadInt = true
 			default:
-					os.Exit(1)
+				saveState()
+				exit(1)
 			}
 		case <-finished:
 		default:
-			if tick.After(endTime) && cmd != nil {
-				pids := append(ps.GetDecendents(cmd.Process.Pid), cmd.Process.Pid)
-				if len(pids) > 0 {
-					printf("Killing runaways\n")
-					killall(pids)
+			if cmd != nil && !timedOut && tick.After(endTime) {
+				timedOut = true
+				if runaway(cmd, finished) {
+					cmd = nil
 				}
-				cmd.Process.Kill()
-				cmd.Wait()
-				cmd = nil
 			}
 		}
+		checkResume()
+		newIgnorePass()
+		handleControl()
+		handleSaves()
 		checkConfig()
-		for _, s := range sets {
-			if s.same() {
-				continue
-			}
-			// A command might still be running.
-			if cmd != nil && cmd.Process != nil {
-				pids := append(ps.GetDecendents(cmd.Process.Pid), cmd.Process.Pid)
-				if len(pids) > 0 {
-					printf("%s Killing old command\n", now())
-					killall(pids)
-					cmd.Process.Kill()
-					printf("%s Waiting for death...\n", now())
-					cmd.Wait()
-				}
+		if d := checkInterval(); d != interval {
+			// The config changed the frequency of a set.
+			interval = d
+			t.Reset(interval)
+		}
+		checkBinaries(func() {
+			if cmd != nil {
+				killGroup(cmd, finished)
 				cmd = nil
 			}
-			endTime = now().Add(flags.Timeout)
-			hadInt = false
-			cmd, finished = s.run()
-			break
-		}
-	}
-}
+			stopKeepAlive()
+			saveState()
+			stopTUI()
+		})
+		checkEnvFile()
+		checkTriggers()
 
-func killall(pids []int) {
-	dead := map[int]bool{}
-	printf("Killing %d\n", pids)
-	for len(dead) < len(pids) {
-		for n := len(pids); n > 0; {
-			n--
-			pid := pids[n]
-			if dead[pid] {
-				continue
-			}
-			if syscall.Kill(pid, 0) != nil {
-				printf("%d exited\n", pid)
-				dead[pid] = true
-				continue
+		// If the running command has finished then the sets that
+		// depend on it may now need to run.
+		select {
+		case <-finished:
+			if running != nil {
+				settle()
+				outputEnd = now()
+				running.runEnd = outputEnd
+				pending = orderSets(append(pending, changedDependents(running, pending)...))
+				running = nil
 			}
-			syscall.Kill(pid, syscall.SIGKILL)
+		default:
 		}
-		time.Sleep(time.Second)
-	}
-	printf("child processed cleaned up\n")
-}
 
-func (s *set) same() bool {
-	// Collect all files currently matching our pattern
-	files, err := MultiGlob(s.patterns)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-	// Compare them with what we have seen before.
-	// Anything left in Seen has been deleted.
-	// Anything not in Seen is new.
-	same := true
-	vclear()
-	for path, f1 := range files {
-		// Skip directories
-		if f1.IsDir() {
-			delete(files, path)
+		if paused || !checkDirs() {
 			continue
 		}
-		f2, ok := s.seen[path]
-		delete(s.seen, path)
-		if !ok || !SameFile(f1, f2) {
-			same = false
-			if !flags.Verbose {
-				// Once we have seen one difference
-				// we can stop checking, unless we are
-				// in verbose mode in which case we
-				// have to keep checking.
-				break
+		if !checkGitHead() {
+			continue
+		}
+		checkCommits()
+
+		resetGitDiff()
+		passStart := time.Now()
+		next, ok := changedSets(tick, interval)
+		if !ok {
+			continue
+		}
+		if verbosity >= 3 && len(next) > 0 {
+			vprintf("Pass took %v\n", time.Since(passStart))
+		}
+		reportProfile(time.Since(passStart))
+		checkFileCounts()
+		if running == nil {
+			// The command, if any, has completed and all its
+			// output has been seen.
+			outputStart, outputEnd = time.Time{}, time.Time{}
+		}
+		next = orderSets(next)
+		if len(next) > 0 && running != nil && !inSets(running, next) {
+			// The running command is not affected by the changes,
+			// e.g., it wrote files a set that runs after it
+			// watches, so let it finish.  The changed sets run
+			// after it and after any of their dependencies.
+			for _, n := range next {
+				if !inSets(n, pending) {
+					pending = append(pending, n)
+				}
+			}
+			pending = orderSets(pending)
+			continue
+		}
+		if len(next) > 0 {
+			// Sets that were waiting to run but are not going to
+			// run now must run later.
+		Pending:
+			for _, p := range pending {
+				for _, n := range next {
+					if p == n {
+						continue Pending
+					}
+				}
+				p.forced = true
 			}
-			if ok {
-				vprintf2("* %s\n", path)
-			} else {
-				vprintf2("+ %s\n", path)
+			// A command might still be running.
+			if cmd != nil {
+				printf("%s Killing old command\n", now())
+				killGroup(cmd, finished)
+				cmd = nil
 			}
+			pending = next
+			running = nil
+		} else if running != nil || len(pending) == 0 {
+			continue
 		} else {
-			vprintf2("= %s\n", path)
+			// Pick up any changes made to the next set's files
+			// while it was waiting so they do not cause it to
+			// run a second time.
+			pending[0].changed(now())
 		}
-	}
-	if len(s.seen) != 0 {
-		if flags.Verbose {
-			for path := range s.seen {
-				vprintf2("- %s\n", path)
+		if hold, skip := holdRuns(); hold {
+			if skip {
+				pending = nil
 			}
+			continue
 		}
-		same = false
+		if holdQuiet(pending) {
+			continue
+		}
+		s := pending[0]
+		pending = pending[1:]
+		endTime = now().Add(commandTimeout())
+		timedOut = false
+		hadInt = false
+		outputStart, outputEnd = now(), time.Time{}
+		s.noteStart(now())
+		cmd, finished = runSet(s)
+		running = s
+		saveState()
 	}
-	s.seen = files
-	return same
 }
 
-func (s *set) run() (*exec.Cmd, chan struct{}) {
-	vadd()
-	clear()
-	vflush()
+// inSets returns true if s is one of sets.
+func inSets(s *set, sets []*set) bool {
+	for _, s2 := range sets {
+		if s2 == s {
+			return true
+		}
+	}
+	return false
+}
 
-	// At this point we assume the spawned processes have
-	// completed.  We forget about them.
+// paused is set while SIGUSR2 has paused autocmd.
+var paused bool
 
-	printf("%s Starting %s\n", now(), s.command)
+// userSignal handles the SIGUSR1 and SIGUSR2 signals, returning false for
+// any other signal.  SIGUSR1 causes all sets to run.  SIGUSR2 toggles
+// whether autocmd is paused.
+func userSignal(sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGUSR1:
+		printf("%s Running all sets\n", now())
+		for _, s := range allSets() {
+			s.forced = true
+		}
+	case syscall.SIGUSR2:
+		paused = !paused
+		ui.setPaused(paused)
+		if paused {
+			printf("%s Paused\n", now())
+		} else {
+			printf("%s Resumed\n", now())
+		}
+	default:
+		return false
+	}
+	return true
+}
 
-	cmd := exec.Command(s.command[0], s.command[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// onTimeoutTimeout is how long the --on-timeout command may run.  It is run
+// from the main loop, holding up autocmd.
+const onTimeoutTimeout = time.Minute
 
-	finished := make(chan struct{})
-	if err := cmd.Start(); err != nil {
-		printf("%v\n", err)
-		cmd = nil
-		close(finished)
-		return nil, finished
+// runaway handles cmd running longer than the timeout.  The --on-timeout
+// command, if any, is run first.  It returns true if cmd was killed, which
+// is the case unless --timeout-action=warn.
+func runaway(cmd *job, finished chan struct{}) bool {
+	printf("%s Command has run longer than %v\n", now(), commandTimeout())
+	if flags.OnTimeout != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), onTimeoutTimeout)
+		defer cancel()
+		hook := shellCmd(ctx, flags.OnTimeout)
+		hook.Env = append(hook.Env, fmt.Sprintf("AUTOCMD_PID=%d", cmd.pid()))
+		hook.Stdout = stdout
+		hook.Stderr = os.Stderr
+		if err := hook.Run(); err != nil {
+			warnf("--on-timeout: %v\n", err)
+		}
 	}
+	if flags.TimeoutAction == "warn" {
+		return false
+	}
+	printf("Killing runaways\n")
+	killGroup(cmd, finished)
+	return true
+}
 
-	go func(cmd *exec.Cmd, finished chan struct{}) {
-		err := cmd.Wait()
-		vprintf("command returns %v\n", err)
-		if err != nil {
-			printf("Command died with %v\n", err)
-		} else {
-			printf("Command exited ")
-		}
-		close(finished)
-	}(cmd, finished)
-	return cmd, finished
+// killGroup kills j and then waits for finished to be closed, indicating
+// j's commands have been waited for.
+func killGroup(j *job, finished chan struct{}) {
+	j.kill()
+	<-finished
+	printf("child processes cleaned up\n")
 }
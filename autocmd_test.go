@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		size int64
+		err  bool
+	}{
+		{in: "0", size: 0},
+		{in: "1024", size: 1024},
+		{in: "10K", size: 10 << 10},
+		{in: "10k", size: 10 << 10},
+		{in: "100M", size: 100 << 20},
+		{in: "2G", size: 2 << 30},
+		{in: "2g", size: 2 << 30},
+		{in: "K", err: true},
+		{in: "1.5M", err: true},
+		{in: "-1", err: true},
+		{in: "10T", err: true},
+		{in: "ten", err: true},
+	} {
+		size, err := parseSize(tt.in)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("parseSize(%q) = %d, want an error", tt.in, size)
+		case !tt.err && err != nil:
+			t.Errorf("parseSize(%q): %v", tt.in, err)
+		case size != tt.size:
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, size, tt.size)
+		}
+	}
+}
+
+func TestCountVerbose(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		n    int
+	}{
+		{"", 0},
+		{"*.go -- go test", 0},
+		{"-v *.go", 1},
+		{"--verbose *.go", 1},
+		{"-v -v --verbose", 3},
+		{"-vv", 2},
+		{"-cv", 1},
+		{"-vcv", 2},
+		{"-c *.go -- go test -v", 0},
+		{"-v -- -v", 1},
+		{"--timeout 5m -v", 1},
+		{"--timeout -v", 0},
+		{"--timeout=5m -v", 1},
+		{"--exclude -v -v", 1},
+		{"--timestamps -v", 1},
+		{"- -v", 0},
+	} {
+		if n := countVerbose(strings.Fields(tt.args)); n != tt.n {
+			t.Errorf("countVerbose(%q) = %d, want %d", tt.args, n, tt.n)
+		}
+	}
+}
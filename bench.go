@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// isSubcommand returns true if args request the subcommand name, e.g.:
+//
+//	autocmd bench [PATTERN ...]
+//
+// A watched file with the same name as a subcommand is always followed by --
+// and a command.
+func isSubcommand(args []string, name string) bool {
+	if len(args) == 0 || args[0] != name {
+		return false
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+	}
+	return true
+}
+
+// bench runs --bench-passes passes over the files matched by patterns and
+// writes the time each pass took, and how much memory it allocated, to w.
+// If no patterns are given, the patterns of the sets in the config file are
+// used, or the --go patterns with --go.
+func bench(w io.Writer, patterns []string) {
+	if len(patterns) == 0 {
+		if flags.Go {
+			patterns = gopatterns
+		}
+		for _, s := range configSets {
+			patterns = append(patterns, s.patterns...)
+		}
+	}
+	if len(patterns) == 0 {
+		fmt.Fprintln(os.Stderr, "bench: no patterns")
+		os.Exit(1)
+	}
+	passes := flags.BenchPasses
+	if passes < 1 {
+		passes = 1
+	}
+	s := &set{patterns: patterns, seen: map[string]os.FileInfo{}}
+	var min, max, total time.Duration
+	var before, after runtime.MemStats
+	var mallocs, bytes uint64
+	for i := 0; i < passes; i++ {
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		s.same()
+		d := time.Since(start)
+		runtime.ReadMemStats(&after)
+		mallocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+		if i == 0 || d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		total += d
+	}
+	n := uint64(passes)
+	fmt.Fprintf(w, "patterns: %q\n", patterns)
+	fmt.Fprintf(w, "files:    %d\n", len(s.seen))
+	fmt.Fprintf(w, "passes:   %d\n", passes)
+	fmt.Fprintf(w, "time:     min %v avg %v max %v\n", min, total/time.Duration(passes), max)
+	fmt.Fprintf(w, "allocs:   %d per pass, %d bytes per pass\n", mallocs/n, bytes/n)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// checkSets writes a report to w of the files matched by each pattern in
+// sets.  It returns false if any pattern does not match a file.
+func checkSets(w io.Writer, sets []*set) bool {
+	ok := true
+	isConfig := map[string]bool{}
+	for _, path := range configFiles {
+		fmt.Fprintf(w, "config: %s\n", path)
+		isConfig[path] = true
+	}
+	for _, path := range excludes {
+		fmt.Fprintf(w, "exclude: %s\n", path)
+	}
+	for _, s := range sets {
+		fmt.Fprintf(w, "set %s: %s\n", s, strings.Join(s.command, " "))
+		for _, p := range s.patterns {
+			// The config files are silently watched by --go, an
+			// optional include need not exist.
+			if isConfig[p] {
+				continue
+			}
+			n, err := countMatches(p)
+			switch {
+			case err != nil:
+				fmt.Fprintf(w, "\t%s: %v\n", p, err)
+				ok = false
+			case n == 0:
+				fmt.Fprintf(w, "\t%s: no files match\n", p)
+				ok = false
+			default:
+				fmt.Fprintf(w, "\t%s: %d files\n", p, n)
+			}
+		}
+	}
+	return ok
+}
+
+// countMatches returns the number of files we would watch, not counting
+// excluded files, that pattern matches.
+func countMatches(pattern string) (int, error) {
+	files, err := MultiGlob([]string{pattern})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for path, fi := range files {
+		if Tracked(fi) && !Excluded(path, excludes) && !ignoredByFile(path, fi.IsDir()) {
+			n++
+		}
+	}
+	return n, nil
+}
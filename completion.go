@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// setOptionWords are the set options offered by completion.
+var setOptionWords = []string{"name=", "after=", "produces=", "on=commit", "frequency=", "every=", "url=", "if="}
+
+// completion writes a completion script for shell, which is bash, zsh, or
+// fish, to w.  The scripts run "autocmd --completion=sets" to find the names
+// of the sets in the config file.
+func completion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		bashCompletion(w)
+	case "zsh":
+		zshCompletion(w)
+	case "fish":
+		fishCompletion(w)
+	default:
+		return fmt.Errorf("unknown shell %q (want bash, zsh, or fish)", shell)
+	}
+	return nil
+}
+
+// printSetNames writes the names of the named sets, one per line, to w.
+func printSetNames(w io.Writer) {
+	for _, s := range allSets() {
+		if s.name != "" {
+			fmt.Fprintln(w, s.name)
+		}
+	}
+}
+
+// bashCompletion writes the bash completion script to w.
+func bashCompletion(w io.Writer) {
+	var words []string
+	for _, f := range flagInfos() {
+		if f.Arg != "" {
+			words = append(words, "--"+f.Name+"=")
+		} else {
+			words = append(words, "--"+f.Name)
+		}
+	}
+	fmt.Fprintf(w, `# bash completion for autocmd
+# Load with: source <(autocmd --completion=bash)
+_autocmd() {
+	local cur=${COMP_LINE:0:COMP_POINT} prev=${COMP_WORDS[COMP_CWORD-1]}
+	cur=${cur##*[[:space:]]}
+	local i mode=patterns trigger=
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		--) mode=command ;;
+		---) mode=patterns ;;
+		--trigger) trigger=1 ;;
+		esac
+	done
+	COMPREPLY=()
+	case $mode,$cur in
+	command,*)
+		if [[ $prev == -- ]]; then
+			COMPREPLY=($(compgen -c -- "$cur"))
+		else
+			COMPREPLY=($(compgen -f -- "$cur") $(compgen -W "---" -- "$cur"))
+		fi
+		return
+		;;
+	*,--*=*)
+		local flag=${cur%%%%=*} value=${cur#*=}
+		case $flag in
+%s		*) COMPREPLY=($(compgen -f -- "$value")) ;;
+		esac
+		return
+		;;
+	*,-*)
+		COMPREPLY=($(compgen -W "%s -- ---" -- "$cur"))
+		[[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace
+		return
+		;;
+	*,after=*)
+		COMPREPLY=($(compgen -W "$(autocmd --completion=sets 2>/dev/null)" -- "${cur#after=}"))
+		return
+		;;
+	esac
+	if [[ -n $trigger ]]; then
+		COMPREPLY=($(compgen -W "$(autocmd --completion=sets 2>/dev/null)" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -f -- "$cur") $(compgen -W "%s" -- "$cur"))
+	[[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace
+}
+complete -o filenames -F _autocmd autocmd
+`, bashValueCases(), strings.Join(words, " "), strings.Join(setOptionWords, " "))
+}
+
+// bashValueCases returns the cases of the bash completion script that
+// complete the values of flags that take one of a fixed set of values.
+func bashValueCases() string {
+	var b strings.Builder
+	for _, f := range flagInfos() {
+		if len(f.Values) > 0 {
+			fmt.Fprintf(&b, "\t\t--%s) COMPREPLY=($(compgen -W %q -- \"$value\")) ;;\n", f.Name, strings.Join(f.Values, " "))
+		}
+	}
+	return b.String()
+}
+
+// zshCompletion writes the zsh completion script to w.
+func zshCompletion(w io.Writer) {
+	fmt.Fprintf(w, `#compdef autocmd
+# zsh completion for autocmd
+# Load with: source <(autocmd --completion=zsh)
+_autocmd() {
+	local curcontext=$curcontext state line ret=1
+	_arguments -s -S \
+`)
+	for _, f := range flagInfos() {
+		help := zshQuote(f.Help)
+		action := ""
+		switch {
+		case len(f.Values) > 0:
+			action = fmt.Sprintf(":%s:(%s)", zshQuote(f.Arg), strings.Join(f.Values, " "))
+		case f.Arg != "":
+			action = fmt.Sprintf(":%s:_files", zshQuote(f.Arg))
+		}
+		eq := ""
+		if f.Arg != "" {
+			eq = "="
+		}
+		if f.Short != "" {
+			fmt.Fprintf(w, "\t\t'(-%s --%s)'{-%[1]s,--%[2]s%s}'[%s]%s' \\\n", f.Short, f.Name, eq, help, action)
+		} else {
+			fmt.Fprintf(w, "\t\t'--%s%s[%s]%s' \\\n", f.Name, eq, help, action)
+		}
+	}
+	fmt.Fprintf(w, `		'*:: :->args' && ret=0
+	case $state in
+	args)
+		local i mode=patterns
+		for ((i = 1; i < CURRENT; i++)); do
+			case $words[i] in
+			--) mode=command ;;
+			---) mode=patterns ;;
+			esac
+		done
+		if [[ $mode == command && $words[CURRENT-1] == -- ]]; then
+			_command_names -e && ret=0
+		elif [[ $mode == command ]]; then
+			_files && ret=0
+			compadd -- --- && ret=0
+		elif [[ $PREFIX == after=* ]]; then
+			compset -P 'after='
+			compadd -- ${(f)"$(autocmd --completion=sets 2>/dev/null)"} && ret=0
+		elif (( ${words[(I)--trigger]} )); then
+			compadd -- ${(f)"$(autocmd --completion=sets 2>/dev/null)"} && ret=0
+		else
+			_files && ret=0
+			compadd -S '' -- %s && ret=0
+			compadd -- -- --- && ret=0
+		fi
+		;;
+	esac
+	return ret
+}
+compdef _autocmd autocmd
+`, strings.Join(setOptionWords, " "))
+}
+
+// zshQuote quotes s for use in a single quoted _arguments spec.
+func zshQuote(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// fishCompletion writes the fish completion script to w.
+func fishCompletion(w io.Writer) {
+	fmt.Fprint(w, `# fish completion for autocmd
+# Load with: autocmd --completion=fish | source
+function __autocmd_mode
+	set -l mode patterns
+	for t in (commandline -opc)
+		switch $t
+		case --
+			set mode command
+		case ---
+			set mode patterns
+		end
+	end
+	echo $mode
+end
+function __autocmd_after_dashdash
+	set -l tokens (commandline -opc)
+	test "$tokens[-1]" = --
+end
+complete -c autocmd -n __autocmd_after_dashdash -f -a '(__fish_complete_command)'
+complete -c autocmd -n 'test (__autocmd_mode) = command' -a ---
+complete -c autocmd -n 'test (__autocmd_mode) = patterns' -a '-- ---'
+`)
+	fmt.Fprintf(w, "complete -c autocmd -n 'test (__autocmd_mode) = patterns' -a '%s'\n", strings.Join(setOptionWords, " "))
+	fmt.Fprint(w, `complete -c autocmd -n 'string match -q "after=*" -- (commandline -ct)' -f -a '(autocmd --completion=sets 2>/dev/null | string replace -r "^" "after=")'
+complete -c autocmd -n '__fish_contains_opt trigger' -f -a '(autocmd --completion=sets 2>/dev/null)'
+`)
+	for _, f := range flagInfos() {
+		args := "-l " + f.Name
+		if f.Short != "" {
+			args = "-s " + f.Short + " " + args
+		}
+		switch {
+		case len(f.Values) > 0:
+			args += fmt.Sprintf(" -x -a '%s'", strings.Join(f.Values, " "))
+		case f.Arg != "":
+			args += " -r"
+		}
+		fmt.Fprintf(w, "complete -c autocmd %s -d '%s'\n", args, strings.ReplaceAll(f.Help, "'", `\'`))
+	}
+}
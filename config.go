@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// maxIncludeDepth is how deeply include directives may be nested.  It
+// protects us from a config that includes itself.
+const maxIncludeDepth = 8
+
+// configVersion is the version of the config format.  A config that starts
+// with a version line is checked strictly: an unknown directive is an error
+// rather than being ignored.  A config without a version line is version 0,
+// which only warns about unknown directives.  autocmd config migrate
+// upgrades a config to the current version.  Version 2 added the
+// quiet-hours directive and the every=, url=, and if= set options.
+const configVersion = 2
+
+// directiveVersions are the directives added since version 1, with the
+// version that added them.
+var directiveVersions = map[string]int{"quiet-hours": 2}
+
+// setOptionVersions are the set options added since version 1, with the
+// version that added them.
+var setOptionVersions = map[string]int{"every": 2, "url": 2, "if": 2}
+
+// newerOption returns the first of the leading options in args, the words
+// of a set, that was added after version, and the version that added it.
+// opt is "" if there is none.
+func newerOption(args []string, version int) (opt string, v int) {
+	for _, arg := range args {
+		key, _, ok := strings.Cut(arg, "=")
+		if !ok {
+			break
+		}
+		if v := setOptionVersions[key]; v > version {
+			return key + "=", v
+		}
+	}
+	return "", 0
+}
+
+// configDirectives are the directives known in the current config version.
+var configDirectives = []string{"version", "go", "exclude", "timeout", "set", "matcher", "include", "quiet-hours"}
+
+// knownDirective returns true if name is in configDirectives.
+func knownDirective(name string) bool {
+	for _, d := range configDirectives {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultGoPatterns are the patterns used by --go when the config does not
+// specify any.
+var defaultGoPatterns = []string{".../*.go"}
+
+// configLayers are the config files that make up the config, from lowest to
+// highest precedence: the default config and then .autocmd, or just the
+// --config file.  A layer need not exist.
+var configLayers []string
+
+// configStats holds the last seen os.FileInfo of each file read for the
+// current configuration (the config file and everything it includes).
+var configStats = map[string]os.FileInfo{}
+
+// configFiles is the list of files read for the current configuration.
+// Included files that do not exist are still listed so we notice if they
+// are created.
+var configFiles []string
+
+// isConfigFile returns true if path is one of configFiles.
+func isConfigFile(path string) bool {
+	path = filepath.Clean(path)
+	for _, f := range configFiles {
+		if filepath.Clean(f) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// configTimeout is the timeout specified by the config file, if any.
+var configTimeout time.Duration
+
+// commandTimeout returns how long a command is allowed to run.  The --timeout
+// flag takes precedence over the config file.
+func commandTimeout() time.Duration {
+	if configTimeout > 0 && !getopt.IsSet("timeout") {
+		return configTimeout
+	}
+	return flags.Timeout
+}
+
+// checkConfig rereads the config if any of its files have changed, been
+// created, or been removed.
+func checkConfig() {
+	if len(configLayers) == 0 {
+		return
+	}
+	changed := false
+	for _, path := range append(configLayers, configFiles...) {
+		f1, err := os.Stat(path)
+		if err != nil {
+			if configStats[path] != nil {
+				// It was removed.
+				delete(configStats, path)
+				changed = true
+			}
+			continue
+		}
+		if f2 := configStats[path]; f2 == nil || !SameFile(f1, f2) {
+			changed = true
+		}
+		configStats[path] = f1
+	}
+	if changed {
+		readConfig(configLayers...)
+	}
+}
+
+// A configParser accumulates the results of parsing a config file and
+// the files it includes.
+type configParser struct {
+	files    []string      // all files read (or attempted to be included)
+	patterns []string      // patterns from go: lines
+	excludes []string      // patterns from exclude: lines
+	timeout  time.Duration // from the timeout: line
+	quiet    *quietHours   // from the quiet-hours: line
+	sets     []*set        // from set: lines
+	matchers []*matcher    // from matcher: lines
+}
+
+// readConfig reads the config from the config files paths, which are
+// layered, later files taking precedence over earlier ones.  Files that do
+// not exist are skipped.  It returns false, leaving the current config in
+// place, if none of the files exist, one has an error, or the sets are not
+// valid.
+//
+// The go patterns of a layer replace those of the layers below it, as do
+// its timeout and quiet hours.  Excludes, sets, and matchers are added to
+// those of the layers below, a matcher hiding one of the same name.
+func readConfig(paths ...string) bool {
+	configLayers = paths
+	var c configParser
+	found := false
+	for _, path := range paths {
+		var layer configParser
+		if err := layer.parse(path, 0); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintln(os.Stderr, err)
+				return false
+			}
+			continue
+		}
+		c.merge(&layer)
+		found = true
+	}
+	if !found {
+		return false
+	}
+	if err := validateSets(append(append([]*set{}, cmdSets...), c.sets...)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", strings.Join(paths, ", "), err)
+		return false
+	}
+	patterns := c.patterns
+	if len(patterns) == 0 {
+		patterns = defaultGoPatterns
+	}
+	gopatterns = append(append([]string{}, patterns...), c.files...)
+	if goset != nil {
+		goset.patterns = gopatterns
+	}
+	excludes = append(append([]string{}, flags.Exclude...), c.excludes...)
+	configTimeout = c.timeout
+	configQuiet = c.quiet
+	configMatchers = c.matchers
+	configSets = mergeSets(configSets, c.sets)
+	pruneURLWatches(allSets())
+	configFiles = c.files
+	return true
+}
+
+// merge merges the config layer l, which takes precedence, into c.
+func (c *configParser) merge(l *configParser) {
+	c.files = append(c.files, l.files...)
+	if len(l.patterns) > 0 {
+		c.patterns = l.patterns
+	}
+	c.excludes = append(c.excludes, l.excludes...)
+	if l.timeout > 0 {
+		c.timeout = l.timeout
+	}
+	if l.quiet != nil {
+		c.quiet = l.quiet
+	}
+	c.sets = append(c.sets, l.sets...)
+	// lookupMatcher finds the first matcher of a name.
+	c.matchers = append(l.matchers, c.matchers...)
+}
+
+// mergeSets returns sets, except that any set in sets that is the same as a
+// set in old is replaced by the set from old.  This prevents rereading the
+// config from causing unchanged sets to run again.  The --keep-alive
+// commands of the sets in old that are dropped are stopped.
+func mergeSets(old, sets []*set) []*set {
+	byKey := map[string]*set{}
+	for _, s := range old {
+		byKey[s.key()] = s
+	}
+	for i, s := range sets {
+		if o := byKey[s.key()]; o != nil {
+			o.freq = s.freq
+			o.gate = s.gate
+			o.setTimer(s.timer())
+			sets[i] = o
+			delete(byKey, s.key())
+		}
+	}
+	for _, s := range old {
+		if byKey[s.key()] == s {
+			stopKeepAlive(s)
+		}
+	}
+	return sets
+}
+
+// splitWords splits s into words separated by white space.  Single and
+// double quotes may be used to include white space in a word and a
+// backslash quotes the following character (except within single quotes).
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word []rune
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word = append(word, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	switch {
+	case quote != 0:
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	case escaped:
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// parse parses the config file at path.  Lines that follow a section
+// header, such as [linux], are only used when running on that operating
+// system.  The [all] section header returns to lines used on every system.
+//
+// The include directive parses the named file as if it were part of this
+// file.  Relative paths are relative to the directory of the including
+// file.  Included files that do not exist are ignored.
+func (c *configParser) parse(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: includes nested too deeply", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c.files = append(c.files, path)
+	active := true
+	version := 0
+	directives := 0 // the number of directives seen
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section := strings.TrimSpace(line[1 : len(line)-1])
+			active = section == "all" || section == runtime.GOOS
+			continue
+		}
+		if !active {
+			continue
+		}
+		cmd := strings.SplitN(line, ":", 2)
+		directives++
+		switch len(cmd) {
+		// case 1: someday for single word commands
+		case 2:
+			value := strings.TrimSpace(cmd[1])
+			name := strings.TrimSpace(cmd[0])
+			if v := directiveVersions[name]; version > 0 && v > version {
+				return fmt.Errorf("%s:%d: %s requires version %d (see autocmd config migrate)", path, n+1, name, v)
+			}
+			switch name {
+			case "version":
+				v, err := strconv.Atoi(value)
+				switch {
+				case err != nil || v < 1:
+					return fmt.Errorf("%s:%d: invalid version: %q", path, n+1, value)
+				case v > configVersion:
+					return fmt.Errorf("%s:%d: config version %d is newer than this autocmd supports (%d)", path, n+1, v, configVersion)
+				case directives > 1:
+					return fmt.Errorf("%s:%d: version must come before any other directive", path, n+1)
+				}
+				version = v
+			case "go":
+				c.patterns = append(c.patterns, expandPattern(value))
+			case "exclude":
+				c.excludes = append(c.excludes, expandPattern(value))
+			case "timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n+1, err)
+					continue
+				}
+				c.timeout = d
+			case "quiet-hours":
+				q, err := parseQuietHours(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n+1, err)
+					continue
+				}
+				c.quiet = q
+			case "set":
+				args, err := splitWords(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n+1, err)
+					continue
+				}
+				if opt, v := newerOption(args, version); version > 0 && opt != "" {
+					return fmt.Errorf("%s:%d: %s requires version %d (see autocmd config migrate)", path, n+1, opt, v)
+				}
+				s, err := parseSet(args)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n+1, err)
+					continue
+				}
+				c.sets = append(c.sets, s)
+			case "matcher":
+				m, err := parseMatcher(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path, n+1, err)
+					continue
+				}
+				c.matchers = append(c.matchers, m)
+			case "include":
+				value = expandPattern(value)
+				if !filepath.IsAbs(value) {
+					value = filepath.Join(filepath.Dir(path), value)
+				}
+				err := c.parse(value, depth+1)
+				switch {
+				case err == nil:
+				case os.IsNotExist(err):
+					c.files = append(c.files, value)
+				default:
+					return err
+				}
+			default:
+				if version > 0 {
+					return fmt.Errorf("%s:%d: unknown directive: %q", path, n+1, name)
+				}
+				fmt.Fprintf(os.Stderr, "%s:%d: unknown directive %q ignored (see autocmd config migrate)\n", path, n+1, name)
+			}
+		default:
+			if version > 0 {
+				return fmt.Errorf("%s:%d: invalid config command: %q", path, n+1, line)
+			}
+			fmt.Fprintf(os.Stderr, "%s:%d: invalid config command: %q\n", path, n+1, line)
+			continue
+		}
+	}
+	return nil
+}
+
+// showConfig writes the effective config, the config layers merged and
+// with the flags applied, to w in the form of a config file.  Sets from the
+// command line are included, following a comment.
+func showConfig(w io.Writer) {
+	for _, path := range configLayers {
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(w, "# %s: not found\n", path)
+		} else {
+			fmt.Fprintf(w, "# %s\n", path)
+		}
+	}
+	fmt.Fprintf(w, "version: %d\n", configVersion)
+	isConfig := map[string]bool{}
+	for _, path := range configFiles {
+		isConfig[path] = true
+	}
+	for _, p := range gopatterns {
+		// The config files are added to the go patterns.
+		if !isConfig[p] {
+			fmt.Fprintf(w, "go: %s\n", p)
+		}
+	}
+	for _, p := range excludes {
+		fmt.Fprintf(w, "exclude: %s\n", p)
+	}
+	fmt.Fprintf(w, "timeout: %v\n", commandTimeout())
+	if configQuiet != nil {
+		fmt.Fprintf(w, "quiet-hours: %v\n", configQuiet)
+	}
+	for _, m := range configMatchers {
+		fmt.Fprintf(w, "matcher: %s %s\n", m.name, m.re)
+	}
+	for _, s := range configSets {
+		fmt.Fprintf(w, "set: %s\n", s.line())
+	}
+	if len(cmdSets) > 0 {
+		fmt.Fprintf(w, "# from the command line\n")
+	}
+	for _, s := range cmdSets {
+		fmt.Fprintf(w, "set: %s\n", s.line())
+	}
+}
+
+// line returns s in the form of the command line, or a config set line,
+// that describes it.
+func (s *set) line() string {
+	var words []string
+	if s.name != "" {
+		words = append(words, "name="+s.name)
+	}
+	if len(s.after) > 0 {
+		words = append(words, "after="+strings.Join(s.after, ","))
+	}
+	if len(s.produces) > 0 {
+		words = append(words, "produces="+strings.Join(s.produces, ","))
+	}
+	if s.freq > 0 {
+		words = append(words, "frequency="+s.freq.String())
+	}
+	if s.gate != "" {
+		words = append(words, "if="+s.gate)
+	}
+	for _, src := range s.sources {
+		if opt := src.option(); opt != "" {
+			words = append(words, opt)
+		}
+	}
+	words = append(words, s.patterns...)
+	words = append(words, "--")
+	words = append(words, s.command...)
+	for i, w := range words {
+		words[i] = quoteWord(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// quoteWord returns w quoted, if necessary, so splitWords returns it as a
+// single word.
+func quoteWord(w string) string {
+	switch {
+	case w == "":
+		return "''"
+	case !strings.ContainsAny(w, " \t'\"\\"):
+		return w
+	case !strings.Contains(w, "'"):
+		return "'" + w + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(w) + `"`
+}
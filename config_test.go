@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		words []string
+		err   bool
+	}{
+		{in: ""},
+		{in: "  \t "},
+		{in: "a", words: []string{"a"}},
+		{in: " a  b\tc ", words: []string{"a", "b", "c"}},
+		{in: `'a b' c`, words: []string{"a b", "c"}},
+		{in: `"a b" c`, words: []string{"a b", "c"}},
+		{in: `a'b c'd`, words: []string{"ab cd"}},
+		{in: `''`, words: []string{""}},
+		{in: `a '' b`, words: []string{"a", "", "b"}},
+		{in: `a\ b`, words: []string{"a b"}},
+		{in: `"a\"b"`, words: []string{`a"b`}},
+		{in: `'a\b'`, words: []string{`a\b`}},
+		{in: `"it's"`, words: []string{"it's"}},
+		{in: `'a`, err: true},
+		{in: `"a`, err: true},
+		{in: `a\`, err: true},
+	} {
+		words, err := splitWords(tt.in)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("splitWords(%q) = %q, want an error", tt.in, words)
+		case !tt.err && err != nil:
+			t.Errorf("splitWords(%q): %v", tt.in, err)
+		case !reflect.DeepEqual(words, tt.words):
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, words, tt.words)
+		}
+	}
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	defer func(layers, files, patterns, excl []string, cmd, config []*set) {
+		configLayers, configFiles, gopatterns, excludes = layers, files, patterns, excl
+		cmdSets, configSets = cmd, config
+	}(configLayers, configFiles, gopatterns, excludes, cmdSets, configSets)
+	cmdSets, configSets = nil, nil
+	path := filepath.Join(t.TempDir(), "autocmd.conf")
+	for _, tt := range []struct {
+		config string
+		ok     bool
+	}{
+		{"set: name=a *.go -- true\n", true},
+		{"set: name=b after=x *.go -- true\n", false},
+		{"set: name=b *.go -- true\nset: name=b *.c -- true\n", false},
+		{"set: name=1 *.go -- true\n", false},
+	} {
+		if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if ok := readConfig(path); ok != tt.ok {
+			t.Errorf("readConfig of %q returned %v, want %v", tt.config, ok, tt.ok)
+		}
+		// Only the first config is valid.
+		if len(configSets) != 1 || configSets[0].name != "a" {
+			t.Errorf("after reading %q the sets are %v, want a", tt.config, configSets)
+		}
+	}
+}
+
+func TestQuoteWord(t *testing.T) {
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"", "''"},
+		{"a", "a"},
+		{"*.go", "*.go"},
+		{"a b", "'a b'"},
+		{"a\tb", "'a\tb'"},
+		{`a"b`, `'a"b'`},
+		{`a\b`, `'a\b'`},
+		{"it's", `"it's"`},
+		{`it's "x"`, `"it's \"x\""`},
+		{`it's a\b`, `"it's a\\b"`},
+	} {
+		out := quoteWord(tt.in)
+		if out != tt.out {
+			t.Errorf("quoteWord(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+		// Whatever quoteWord returns must split back into the word.
+		if words, err := splitWords(out); err != nil || len(words) != 1 || words[0] != tt.in {
+			t.Errorf("splitWords(quoteWord(%q)) = %q, %v", tt.in, words, err)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	version := fmt.Sprintf("version: %d\n", configVersion)
+	for _, tt := range []struct {
+		name  string
+		in    string
+		out   string
+		notes []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "comments only",
+			in:   "# nothing\n\n",
+			out:  "# nothing\n\n",
+		},
+		{
+			name: "version 0",
+			in:   "# my config\ngo: *.go\ntimeout: 5m\n",
+			out:  "# my config\n" + version + "go: *.go\ntimeout: 5m\n",
+		},
+		{
+			name:  "version 0 unknown directive",
+			in:    "go: *.go\ncolour: red\nnonsense\n",
+			out:   version + "go: *.go\n# colour: red\n# nonsense\n",
+			notes: []string{`2: commented out "colour: red", which was ignored`, `3: commented out "nonsense", which was ignored`},
+		},
+		{
+			name: "version 0 section",
+			in:   "[linux]\ngo: *.go\n",
+			out:  version + "[linux]\ngo: *.go\n",
+		},
+		{
+			name:  "version 1",
+			in:    "version: 1\nquiet-hours: 22:00-07:00\n",
+			out:   version + "quiet-hours: 22:00-07:00\n",
+			notes: []string{fmt.Sprintf("1: updated the version from 1 to %d", configVersion)},
+		},
+		{
+			name: "current",
+			in:   version + "go: *.go\n",
+			out:  version + "go: *.go\n",
+		},
+	} {
+		out, notes := migrateConfig(tt.in)
+		if out != tt.out {
+			t.Errorf("%s: got config:\n%s\nwant:\n%s", tt.name, out, tt.out)
+		}
+		if !reflect.DeepEqual(notes, tt.notes) {
+			t.Errorf("%s: got notes %q, want %q", tt.name, notes, tt.notes)
+		}
+		// Migrating again changes nothing.
+		if again, notes := migrateConfig(out); again != out || len(notes) != 0 {
+			t.Errorf("%s: migrating again got:\n%s\nnotes %q", tt.name, again, notes)
+		}
+	}
+}
+
+func TestNewerOption(t *testing.T) {
+	for _, tt := range []struct {
+		args    string
+		version int
+		opt     string
+	}{
+		{"*.go -- go test", 1, ""},
+		{"name=x after=y *.go -- go test", 1, ""},
+		{"name=x every=1h -- true", 1, "every="},
+		{"url=http://x -- true", 1, "url="},
+		{"if=true *.go -- true", 1, "if="},
+		{"*.go if=true -- true", 1, ""},
+		{"name=x every=1h -- true", 2, ""},
+	} {
+		opt, _ := newerOption(strings.Fields(tt.args), tt.version)
+		if opt != tt.opt {
+			t.Errorf("newerOption(%q, %d) = %q, want %q", tt.args, tt.version, opt, tt.opt)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// containerSeq is used to give each command run in a container, or on the
+// --sync host, its own process ID file.
+var containerSeq int64
+
+// targetCommand returns the command to start in order to run command, which
+// may be in a container (--docker or --kubectl) or the --sync destination
+// (--sync-exec).  Any cleanup needed when j is killed is registered with j.
+func targetCommand(j *job, command []string) []string {
+	if flags.Docker != "" || flags.Kubectl != "" {
+		return containerCommand(j, command)
+	}
+	return syncCommand(j, command)
+}
+
+// containerExec returns the command that runs a command in the container,
+// which is either a docker container (--docker) or a container in a
+// Kubernetes pod (--kubectl).
+func containerExec() []string {
+	if flags.Kubectl == "" {
+		return []string{"docker", "exec", flags.Docker}
+	}
+	pod, container, _ := strings.Cut(flags.Kubectl, ":")
+	args := []string{"kubectl", "exec", pod}
+	if container != "" {
+		args = append(args, "-c", container)
+	}
+	return append(args, "--")
+}
+
+// containerCommand returns a command that runs command in the container.
+// Killing the local docker or kubectl command does not kill command, so the
+// shell in the container records its process ID and j kills its process group,
+// or failing that it and its children, when j is killed.  The shell removes
+// the file holding the process ID when command exits.
+func containerCommand(j *job, command []string) []string {
+	pidfile := fmt.Sprintf("/tmp/autocmd-%d-%d.pid", os.Getpid(), atomic.AddInt64(&containerSeq, 1))
+	j.atKill(func() {
+		kill := fmt.Sprintf(`pid=$(cat %s) && { kill -KILL -- -$pid || pkill -KILL -P $pid; kill -KILL $pid; } 2>/dev/null; rm -f %[1]s`, pidfile)
+		exec.Command(containerExec()[0], append(containerExec()[1:], "sh", "-c", kill)...).Run()
+	})
+	script := fmt.Sprintf(`echo $$ > %s; "$@"; status=$?; rm -f %[1]s; exit $status`, pidfile)
+	return append(append(containerExec(), "sh", "-c", script, "sh"), command...)
+}
+
+// checkContainer returns an error if the container cannot be used.
+func checkContainer() error {
+	out, err := exec.Command(containerExec()[0], append(containerExec()[1:], "true")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(containerExec(), " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
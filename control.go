@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A controlRequest is a request received on the control socket.  Requests
+// are handled by the main loop, which sends the response on reply.
+type controlRequest struct {
+	args  []string
+	reply chan string
+}
+
+var controlChan = make(chan controlRequest)
+
+// socketPath returns the path of the control socket.  Unless specified by
+// --socket, the path is derived from the current directory so each project
+// has its own socket.
+func socketPath() string {
+	if flags.Socket != "" {
+		return flags.Socket
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+	sum := sha256.Sum256([]byte(dir))
+	rdir := os.Getenv("XDG_RUNTIME_DIR")
+	if rdir == "" {
+		rdir = os.TempDir()
+	}
+	return filepath.Join(rdir, fmt.Sprintf("autocmd-%d-%x.sock", os.Getuid(), sum[:8]))
+}
+
+// listenControl starts listening for requests on the control socket.  It
+// is not an error for the socket to be unavailable, autocmd simply cannot be
+// controlled.
+func listenControl() {
+	path := socketPath()
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		fmt.Fprintf(os.Stderr, "Another autocmd is listening on %s\n", path)
+		return
+	}
+	// Any existing socket is stale.
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		vprintf("control socket: %v\n", err)
+		return
+	}
+	os.Chmod(path, 0600)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(c)
+		}
+	}()
+}
+
+// serveControl reads a single request from c, passes it to the main loop,
+// and writes the response back to c.
+func serveControl(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Minute))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	if args[0] == "attach" {
+		serveAttach(c)
+		return
+	}
+	req := controlRequest{args: args, reply: make(chan string, 1)}
+	controlChan <- req
+	fmt.Fprintln(c, <-req.reply)
+}
+
+// sendControl sends args as a request to the running autocmd and returns
+// its response.
+func sendControl(args ...string) (string, error) {
+	c, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return "", fmt.Errorf("autocmd does not appear to be running: %v", err)
+	}
+	defer c.Close()
+	fmt.Fprintln(c, strings.Join(args, " "))
+	resp, err := io.ReadAll(c)
+	return strings.TrimSpace(string(resp)), err
+}
+
+// handleControl handles any pending control requests.  It must only be
+// called from the main loop.
+func handleControl() {
+	for {
+		select {
+		case req := <-controlChan:
+			req.reply <- doControl(req.args)
+		default:
+			return
+		}
+	}
+}
+
+func doControl(args []string) string {
+	switch args[0] {
+	case "trigger":
+		s, err := findSet(args[1:])
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		s.forced = true
+		return "ok"
+	case "history":
+		return historyText()
+	case "files":
+		return filesText()
+	default:
+		return fmt.Sprintf("error: unknown request %q", args[0])
+	}
+}
+
+// findSet returns the set named by args, which is either empty (the first
+// set), the name of the set, or the number of the set, starting at 1.
+func findSet(args []string) (*set, error) {
+	sets := allSets()
+	switch len(args) {
+	case 0:
+		return sets[0], nil
+	case 1:
+		if s := lookupSet(sets, args[0]); s != nil {
+			return s, nil
+		}
+		return nil, fmt.Errorf("no such set: %s", args[0])
+	default:
+		return nil, fmt.Errorf("too many arguments")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseCoverage returns the coverage, in percent, of each package reported
+// in out, the output of go test -cover, with or without -json.
+func parseCoverage(out []byte) map[string]float64 {
+	cover := map[string]float64{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if ev, ok := parseGoTestEvent(line); ok {
+			line = ev.Output
+		}
+		// ok  	example.com/foo	0.012s	coverage: 85.7% of statements
+		//	example.com/bar		coverage: 0.0% of statements
+		x := strings.Index(line, "coverage: ")
+		if x < 0 || !strings.Contains(line[x:], "% of statements") {
+			continue
+		}
+		fields := strings.Fields(line[:x])
+		if len(fields) > 0 && fields[0] == "ok" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		pct, _, _ := strings.Cut(line[x+len("coverage: "):], "%")
+		if p, err := strconv.ParseFloat(pct, 64); err == nil {
+			cover[fields[0]] = p
+		}
+	}
+	return cover
+}
+
+// writeCoverage updates path with the coverage of the packages reported in
+// out.  The file has a line for each package of the form PACKAGE PERCENT,
+// e.g., "example.com/foo 85.7".  Packages not reported in out keep the
+// coverage they last had.
+func writeCoverage(path string, out []byte) error {
+	cover := parseCoverage(out)
+	if len(cover) == 0 {
+		return nil
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			if _, ok := cover[fields[0]]; ok {
+				continue
+			}
+			if p, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				cover[fields[0]] = p
+			}
+		}
+	}
+	pkgs := make([]string, 0, len(cover))
+	for pkg := range cover {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	var b strings.Builder
+	for _, pkg := range pkgs {
+		fmt.Fprintf(&b, "%s %.1f\n", pkg, cover[pkg])
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
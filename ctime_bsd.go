@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ctime returns the time the inode described by fi last changed.
+func ctime(fi os.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Ctimespec.Unix()), true
+}
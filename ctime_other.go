@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// ctime returns the time the inode described by fi last changed.  It is not
+// supported on this system.
+func ctime(fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A dirCache is the list of directories found by walking a tree.
+type dirCache struct {
+	dirs   []string
+	infos  []os.FileInfo // the os.FileInfo of each directory in dirs
+	walked time.Time     // when the tree was walked
+}
+
+// defaultIgnoreDirs are the names of directories that are not descended
+// into when expanding ... unless --no-default-ignores is specified.
+var defaultIgnoreDirs = []string{"node_modules", ".hg", ".svn", "target", "dist"}
+
+// ignoreDir returns true if we should not descend into directories named
+// name.
+func ignoreDir(name string) bool {
+	if name == ".git" {
+		return !flags.Git
+	}
+	if !flags.NoIgnore && matchAny(defaultIgnoreDirs, name) {
+		return true
+	}
+	return matchAny(flags.IgnoreDir, name)
+}
+
+// matchAny returns true if name matches any of patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// limitWarned records the roots we have warned about reaching --max-files
+// or --max-depth so we only warn once.
+var limitWarned = map[string]bool{}
+
+// dirCaches are the cached directory lists, indexed by the root of the tree.
+var dirCaches = map[string]*dirCache{}
+
+// walkDirs returns the directories in the tree rooted at root, including
+// root.  Directories for which ignoreDir returns true are skipped, as are
+// those belonging to a different source than root (see --source).
+//
+// The results are cached for up to --rescan.  The cached results are used
+// as long as none of the directories have changed.
+func walkDirs(root string) []string {
+	c := dirCaches[root]
+	if c != nil && c.valid() {
+		return c.dirs
+	}
+	c = &dirCache{walked: now()}
+	visited := 0
+	var limit string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		visited++
+		if flags.MaxFiles > 0 && visited > flags.MaxFiles {
+			limit = fmt.Sprintf("visited more than %d files (see --max-files)", flags.MaxFiles)
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if info == nil || !info.IsDir() {
+			return nil
+		}
+		if path != root && (ignoreDir(filepath.Base(path)) || ignoredByFile(path, true) || foreign(root, path)) {
+			return filepath.SkipDir
+		}
+		if flags.MaxDepth > 0 && depth(root, path) > flags.MaxDepth {
+			limit = fmt.Sprintf("not descending more than %d directories (see --max-depth)", flags.MaxDepth)
+			return filepath.SkipDir
+		}
+		c.dirs = append(c.dirs, path)
+		c.infos = append(c.infos, info)
+		return nil
+	})
+	if limit != "" && !limitWarned[root] {
+		fmt.Fprintf(os.Stderr, "Warning: expanding %s/...: %s\n", root, limit)
+		limitWarned[root] = true
+	}
+	if flags.Rescan > 0 {
+		dirCaches[root] = c
+	}
+	return c.dirs
+}
+
+// depth returns how many directories below root path is.
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// valid returns true if c is not too old and none of its directories have
+// been modified or removed.
+func (c *dirCache) valid() bool {
+	if now().Sub(c.walked) >= flags.Rescan {
+		return false
+	}
+	for i, dir := range c.dirs {
+		fi, err := os.Lstat(dir)
+		if err != nil || !fi.ModTime().Equal(c.infos[i].ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// A globCache is the cached result of a glob of a single directory.
+type globCache struct {
+	mtime   time.Time // modification time of the directory
+	matches []string
+}
+
+// globCaches are the cached globs, indexed by pattern.
+var globCaches = map[string]*globCache{}
+
+// glob returns filepath.Glob(pattern).  When only the last element of
+// pattern contains metacharacters, the result is cached and only recomputed
+// when the directory's modification time changes.  Checking the
+// modification time is much cheaper than reading the directory.
+//
+// A directory modified within the last 2 seconds is not cached as further
+// changes might not change its modification time.
+func glob(pattern string) ([]string, error) {
+	dir, base := filepath.Split(pattern)
+	if !hasMeta(base) || hasMeta(dir) || flags.Rescan <= 0 {
+		return filepath.Glob(pattern)
+	}
+	if _, err := filepath.Match(base, ""); err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		dir = "."
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		delete(globCaches, pattern)
+		return nil, nil
+	}
+	c := globCaches[pattern]
+	if c != nil && c.mtime.Equal(fi.ModTime()) {
+		return c.matches, nil
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil, nil
+	}
+	names, _ := fd.Readdirnames(-1)
+	fd.Close()
+	var matches []string
+	for _, name := range names {
+		if ok, _ := filepath.Match(base, name); ok {
+			matches = append(matches, filepath.Join(dir, name))
+		}
+	}
+	if now().Sub(fi.ModTime()) > 2*time.Second {
+		globCaches[pattern] = &globCache{mtime: fi.ModTime(), matches: matches}
+	} else {
+		delete(globCaches, pattern)
+	}
+	return matches, nil
+}
+
+// hasMeta reports whether path contains any of the magic characters
+// recognized by filepath.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// lastCheck is the wall clock time of the last check, see checkResume.
+var lastCheck time.Time
+
+// journalPos is the position in the change journal (FSEvents on macOS) as of
+// the last check.  journalVol identifies the journal, and is "" if there is
+// no journal.
+var (
+	journalPos uint64
+	journalVol string
+)
+
+// checkResume detects that autocmd, or the whole machine, was suspended,
+// e.g., a laptop was asleep, by noticing that much more wall clock time has
+// passed since the last check than expected.  Changes made while suspended
+// might not be reflected in directory modification times on every file
+// system.  If the change journal says what changed, only the cached
+// directories and globs it affects are discarded, otherwise they all are so
+// that everything is rescanned.
+func checkResume() {
+	t := now().Round(0) // strip the monotonic clock reading
+	last, pos := lastCheck, journalPos
+	lastCheck = t
+	if p, ok := journalNow(); ok {
+		journalPos = p
+		if journalVol == "" {
+			journalVol, _ = journalVolume(".")
+		}
+	}
+	if last.IsZero() {
+		return
+	}
+	gap := t.Sub(last)
+	if gap < 5*time.Second || gap < 5*flags.Frequency {
+		return
+	}
+	if vol, _ := journalVolume("."); vol != "" && vol == journalVol && pos != 0 {
+		if paths, ok := journalChanges("/", pos); ok {
+			printf("%s Resumed after %v, %d changes\n", now(), gap.Round(time.Second), len(paths))
+			forgetCaches(paths)
+			return
+		}
+	}
+	journalVol, _ = journalVolume(".")
+	printf("%s Resumed after %v, rescanning\n", now(), gap.Round(time.Second))
+	dirCaches = map[string]*dirCache{}
+	globCaches = map[string]*globCache{}
+}
+
+// forgetCaches discards the cached directories and globs that might be
+// affected by changes to paths, which are absolute.
+func forgetCaches(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	dirs := map[string]bool{}
+	for _, p := range paths {
+		dirs[filepath.Dir(p)] = true
+		dirs[p] = true // it might be a directory
+	}
+	for root := range dirCaches {
+		r := realPath(root)
+		for _, p := range paths {
+			if p == r || strings.HasPrefix(p, r+"/") {
+				delete(dirCaches, root)
+				break
+			}
+		}
+	}
+	for pattern := range globCaches {
+		if dirs[realPath(filepath.Dir(pattern))] {
+			delete(globCaches, pattern)
+		}
+	}
+}
+
+// realPath returns the absolute path of path with any symbolic links
+// resolved, as the change journal reports paths, or path made absolute if
+// that fails.
+func realPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	return path
+}
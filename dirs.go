@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// The current directory, or a --root directory, may be removed while
+// autocmd is running, e.g., by switching to a branch without it or by
+// rebuilding a container.  Rather than failing to find files each pass,
+// autocmd waits for the directory to return, for up to --dir-wait, and then
+// rescans.
+var (
+	workDir      string    // the absolute path of the current directory
+	missingSince time.Time // when a directory went missing, or zero
+)
+
+// initDirs records the current directory.
+func initDirs() {
+	workDir, _ = os.Getwd()
+}
+
+// checkDirs returns true if the current directory and the --root
+// directories all exist.  It must only be called from the main loop.
+func checkDirs() bool {
+	dir := missingDir()
+	switch {
+	case dir == "" && missingSince.IsZero():
+		return true
+	case dir == "":
+		printf("%s Directories are back after %v, rescanning\n", now(), now().Sub(missingSince).Round(time.Second))
+		missingSince = time.Time{}
+		dirCaches = map[string]*dirCache{}
+		globCaches = map[string]*globCache{}
+		return true
+	case missingSince.IsZero():
+		missingSince = now()
+		warnf("%s %s has disappeared, waiting for it to return\n", now(), dir)
+	case flags.DirWait > 0 && now().Sub(missingSince) > flags.DirWait:
+		warnf("%s %s has been missing for more than %v, exiting\n", now(), dir, flags.DirWait)
+		select {
+		case intChan <- syscall.SIGTERM:
+		default:
+		}
+	}
+	return false
+}
+
+// missingDir returns the first of the current directory and the --root
+// directories that does not exist, or "" if they all exist.  If the
+// current directory was removed and created again autocmd changes to the
+// new one.
+func missingDir() string {
+	if workDir != "" {
+		fi, err := os.Stat(workDir)
+		if err != nil {
+			return workDir
+		}
+		if cur, err := os.Stat("."); err != nil || !os.SameFile(fi, cur) {
+			if err := os.Chdir(workDir); err != nil {
+				return workDir
+			}
+		}
+	}
+	for _, root := range flags.Root {
+		if _, err := os.Stat(root); err != nil {
+			return root
+		}
+	}
+	return ""
+}
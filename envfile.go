@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// envFile is the file named by --env-file.  env are the variables last read
+// from it, which are added to the environment of each command.  env is
+// replaced, never modified, and is protected by mu as commands are started
+// outside the main loop.
+var envFile struct {
+	fi  os.FileInfo
+	mu  sync.Mutex
+	env []string
+}
+
+// envFileVars returns the variables last read from the --env-file, or nil
+// if there is none.
+func envFileVars() []string {
+	envFile.mu.Lock()
+	defer envFile.mu.Unlock()
+	return envFile.env
+}
+
+// setEnvFileVars sets the variables read from the --env-file to env.
+func setEnvFileVars(env []string) {
+	envFile.mu.Lock()
+	envFile.env = env
+	envFile.mu.Unlock()
+}
+
+// commandEnv returns the environment for the shell commands autocmd runs
+// other than the commands of sets, e.g., if= and --bell-cmd: the
+// environment of autocmd plus the variables from the --env-file.
+func commandEnv() []string {
+	return append(os.Environ(), envFileVars()...)
+}
+
+// readEnvFile reads the KEY=VALUE lines of the environment file at path.
+// Blank lines and lines starting with # are ignored, a line may start with
+// export, and a value may be enclosed in single or double quotes.
+func readEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: not KEY=VALUE: %s", path, n+1, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, nil
+}
+
+// loadEnvFile reads the file named by --env-file, if any.  Any error is
+// fatal.
+func loadEnvFile() {
+	if flags.EnvFile == "" {
+		return
+	}
+	fi, err := os.Stat(flags.EnvFile)
+	var env []string
+	if err == nil {
+		env, err = readEnvFile(flags.EnvFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--env-file: %v\n", err)
+		os.Exit(1)
+	}
+	setEnvFileVars(env)
+	envFile.fi = fi
+}
+
+// checkEnvFile rereads the file named by --env-file if it has changed and
+// forces all sets to run with the new environment.  If the file cannot be
+// read the previous environment is kept.
+func checkEnvFile() {
+	if flags.EnvFile == "" {
+		return
+	}
+	fi, err := os.Stat(flags.EnvFile)
+	if err != nil || SameFile(fi, envFile.fi) {
+		return
+	}
+	envFile.fi = fi
+	env, err := readEnvFile(flags.EnvFile)
+	if err != nil {
+		warnf("--env-file: %v, keeping the previous environment\n", err)
+		return
+	}
+	printf("%s %s changed\n", now(), flags.EnvFile)
+	setEnvFileVars(env)
+	for _, s := range allSets() {
+		s.forced = true
+	}
+}
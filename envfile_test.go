@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		data string
+		env  []string
+		err  bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "comments and blank lines",
+			data: "# comment\n\n  \n  # indented comment\n",
+		},
+		{
+			name: "variables",
+			data: "A=1\nB = two \nexport C=3\nD=\n",
+			env:  []string{"A=1", "B=two", "C=3", "D="},
+		},
+		{
+			name: "quotes",
+			data: "A=\"a b\"\nB='c d'\nC=\"e'\nD=\"\n",
+			env:  []string{"A=a b", "B=c d", `C="e'`, `D="`},
+		},
+		{
+			name: "equals in value",
+			data: "URL=http://x/?a=b\n",
+			env:  []string{"URL=http://x/?a=b"},
+		},
+		{
+			name: "no equals",
+			data: "A=1\nnonsense\n",
+			err:  true,
+		},
+		{
+			name: "no key",
+			data: "=1\n",
+			err:  true,
+		},
+		{
+			name: "space in key",
+			data: "A B=1\n",
+			err:  true,
+		},
+	} {
+		path := filepath.Join(dir, "env")
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		env, err := readEnvFile(path)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: got %q, want an error", tt.name, env)
+		case !tt.err && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case !reflect.DeepEqual(env, tt.env):
+			t.Errorf("%s: got %q, want %q", tt.name, env, tt.env)
+		}
+	}
+	if _, err := readEnvFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("reading a missing file did not fail")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etaRuns is how many of the most recent runs of a set are used to estimate
+// how long the set takes to run.
+const etaRuns = 10
+
+// minSlowRuns is how many runs a set must have completed before a run that
+// takes more than twice as long as usual is warned about.
+const minSlowRuns = 3
+
+// slowTimers are the timers that warn when a running run is slow.
+var (
+	slowMu     sync.Mutex
+	slowTimers = map[*result]*time.Timer{}
+)
+
+// typicalDuration returns the median duration of the last etaRuns runs of
+// set in the history, and how many runs that was.  Runs that were killed
+// are not counted as they did not run to completion.
+func typicalDuration(set string) (time.Duration, int) {
+	historyMu.Lock()
+	var ds []float64
+	for i := len(history) - 1; i >= 0 && len(ds) < etaRuns; i-- {
+		if r := history[i]; r.Set == set && !r.Killed {
+			ds = append(ds, r.Duration)
+		}
+	}
+	historyMu.Unlock()
+	if len(ds) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(ds)
+	d := time.Duration(ds[len(ds)/2] * float64(time.Second))
+	return d, len(ds)
+}
+
+// etaText returns how long runs of set typically take, e.g., "~40s based on
+// last 10 runs", or "" if set has no history.
+func etaText(set string) string {
+	d, n := typicalDuration(set)
+	if n == 0 {
+		return ""
+	}
+	runs := "runs"
+	if n == 1 {
+		runs = "run"
+	}
+	return fmt.Sprintf("~%v based on last %d %s", roundDuration(d), n, runs)
+}
+
+// roundDuration rounds d to a precision suitable for showing to a person.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second)
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// watchSlow arranges to warn if r runs for more than twice as long as runs
+// of its set typically take.
+func watchSlow(r *result) {
+	d, n := typicalDuration(r.Set)
+	if n < minSlowRuns || d <= 0 {
+		return
+	}
+	slowMu.Lock()
+	defer slowMu.Unlock()
+	slowTimers[r] = time.AfterFunc(2*d, func() {
+		warnf("%s: running for %v, more than twice its typical %v\n", strings.Join(r.Command, " "), roundDuration(now().Sub(r.Start)), roundDuration(d))
+	})
+}
+
+// stopSlow stops watching r, which has completed.
+func stopSlow(r *result) {
+	slowMu.Lock()
+	defer slowMu.Unlock()
+	if t := slowTimers[r]; t != nil {
+		t.Stop()
+		delete(slowTimers, r)
+	}
+}
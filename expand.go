@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// expandPattern returns pattern with a leading ~ or ~USER replaced by the
+// home directory and $VAR or ${VAR} replaced by the value of the
+// environment variable VAR.  A variable that is not set is left as is so a
+// pattern does not silently become a pattern for the root directory, see
+// expandVars.
+// Nothing is expanded with --no-expand.
+func expandPattern(pattern string) string {
+	if flags.NoExpand {
+		return pattern
+	}
+	pattern = expandVars(pattern)
+	if !strings.HasPrefix(pattern, "~") {
+		return pattern
+	}
+	name, rest, _ := strings.Cut(pattern[1:], "/")
+	var home string
+	if name == "" {
+		home, _ = os.UserHomeDir()
+	} else if u, err := user.Lookup(name); err == nil {
+		home = u.HomeDir
+	}
+	if home == "" {
+		return pattern
+	}
+	return filepath.Join(home, rest)
+}
+
+// expandPatterns returns patterns with each pattern expanded by
+// expandPattern.
+func expandPatterns(patterns []string) []string {
+	if len(patterns) == 0 {
+		return patterns
+	}
+	expanded := make([]string, len(patterns))
+	for i, p := range patterns {
+		expanded[i] = expandPattern(p)
+	}
+	return expanded
+}
+
+// expandVars returns s with each $VAR or ${VAR} replaced by the value of
+// the environment variable VAR.  Anything else, including a reference to a
+// variable that is not set, a $ not followed by a name, and a ${ without a
+// closing }, is copied as is.
+func expandVars(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		var name string
+		var n int // the length of the reference
+		if strings.HasPrefix(s, "${") {
+			if end := strings.IndexByte(s, '}'); end > 2 {
+				name, n = s[2:end], end+1
+			}
+		} else {
+			for n = 1; n < len(s) && isIdentChar(s[n]); n++ {
+			}
+			name = s[1:n]
+		}
+		v, ok := "", false
+		if name != "" {
+			v, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			b.WriteByte('$')
+			s = s[1:]
+			continue
+		}
+		b.WriteString(v)
+		s = s[n:]
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
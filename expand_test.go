@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandPattern(t *testing.T) {
+	t.Setenv("AUTOCMD_ROOT", "/src")
+	t.Setenv("AUTOCMD_EMPTY", "")
+	os.Unsetenv("AUTOCMD_UNSET")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"foo.go", "foo.go"},
+		{"$AUTOCMD_ROOT/.../*.go", "/src/.../*.go"},
+		{"${AUTOCMD_ROOT}/*.go", "/src/*.go"},
+		{"${AUTOCMD_ROOT}x/*.go", "/srcx/*.go"},
+		{"$AUTOCMD_EMPTY/*.go", "/*.go"},
+		{"$AUTOCMD_UNSET/*.go", "$AUTOCMD_UNSET/*.go"},
+		{"${AUTOCMD_UNSET}/*.go", "${AUTOCMD_UNSET}/*.go"},
+		{"foo$AUTOCMD_UNSET.go", "foo$AUTOCMD_UNSET.go"},
+		{"cost$5.txt", "cost$5.txt"},
+		{"x${y", "x${y"},
+		{"x${}", "x${}"},
+		{"a$", "a$"},
+		{"$$", "$$"},
+		{"$*.go", "$*.go"},
+		{"${AUTOCMD_ROOT", "${AUTOCMD_ROOT"},
+		{"~", home},
+		{"~/src/*.go", filepath.Join(home, "src/*.go")},
+		{"a~/b", "a~/b"},
+	} {
+		if out := expandPattern(tt.in); out != tt.out {
+			t.Errorf("expandPattern(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestNoExpand(t *testing.T) {
+	t.Setenv("AUTOCMD_ROOT", "/src")
+	defer func(no bool) { flags.NoExpand = no }(flags.NoExpand)
+	flags.NoExpand = true
+	for _, in := range []string{"$AUTOCMD_ROOT/*.go", "~/*.go"} {
+		if out := expandPattern(in); out != in {
+			t.Errorf("expandPattern(%q) = %q with --no-expand", in, out)
+		}
+	}
+}
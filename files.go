@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// manyFiles is how many files a set can track before autocmd warns that its
+// patterns probably match more than was intended.
+const manyFiles = 100000
+
+// A set that suddenly tracks growthFactor times as many files as it did,
+// and at least minGrowthFiles files, is warned about.  This usually means
+// a build or dependency directory appeared under one of its patterns.
+const (
+	growthFactor   = 10
+	minGrowthFiles = 1000
+)
+
+// fileEntrySize is roughly how many bytes each file tracked by a set uses,
+// not counting its name: the map entry and the os.FileInfo.  Each file is in
+// both seen and spare.
+const fileEntrySize = 2 * 250
+
+// seenBytes returns roughly how much memory the maps of the files tracked
+// by s use.
+func (s *set) seenBytes() int {
+	n := 0
+	for name := range s.seen {
+		n += len(name) + fileEntrySize
+	}
+	return n
+}
+
+// checkFileCounts warns about sets that track more files than expected.  It
+// must only be called from the main loop.
+func checkFileCounts() {
+	for _, s := range allSets() {
+		n := len(s.seen)
+		switch {
+		case s.onCommit:
+		case n >= manyFiles && s.tracked < manyFiles:
+			warnf("set %s: watching %d files, check the patterns and --exclude\n", s, n)
+		case s.tracked > 0 && n >= minGrowthFiles && n >= growthFactor*s.tracked:
+			warnf("set %s: now watching %d files, up from %d; did a build directory appear?\n", s, n, s.tracked)
+		}
+		s.tracked = n
+	}
+}
+
+// filesText returns how many files each set is watching, and roughly how
+// much memory that takes, for autocmd files.
+func filesText() string {
+	var b strings.Builder
+	total := 0
+	for _, s := range allSets() {
+		n := s.seenBytes()
+		total += n
+		fmt.Fprintf(&b, "set %s: %d files, %s\n", s, len(s.seen), byteSize(n))
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(&b, "total: %s for files, %s heap", byteSize(total), byteSize(int(mem.HeapAlloc)))
+	return b.String()
+}
+
+// byteSize returns n bytes in human readable form, e.g., 1.5MB.
+func byteSize(n int) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,154 @@
+//go:build darwin && cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -framework CoreServices
+#include <CoreServices/CoreServices.h>
+#include <dispatch/dispatch.h>
+#include <stdlib.h>
+#include <string.h>
+
+// A journal holds the paths FSEvents replayed from its history.
+typedef struct {
+	char **paths;
+	int n, cap;
+	int done; // the end of the history was reached
+	int lost; // events were lost, so everything must be rescanned
+	dispatch_semaphore_t sem;
+} journal;
+
+static void journalEvents(ConstFSEventStreamRef stream, void *info, size_t n, void *paths, const FSEventStreamEventFlags flags[], const FSEventStreamEventId ids[]) {
+	journal *j = info;
+	char **p = paths;
+	const FSEventStreamEventFlags lost = kFSEventStreamEventFlagMustScanSubDirs |
+		kFSEventStreamEventFlagUserDropped | kFSEventStreamEventFlagKernelDropped |
+		kFSEventStreamEventFlagEventIdsWrapped | kFSEventStreamEventFlagRootChanged;
+	for (size_t i = 0; i < n && !j->done; i++) {
+		if (flags[i] & kFSEventStreamEventFlagHistoryDone) {
+			j->done = 1;
+			dispatch_semaphore_signal(j->sem);
+			break;
+		}
+		if (flags[i] & lost) {
+			j->lost = 1;
+		}
+		if (j->n == j->cap) {
+			j->cap = j->cap ? 2 * j->cap : 64;
+			j->paths = realloc(j->paths, j->cap * sizeof *j->paths);
+		}
+		j->paths[j->n++] = strdup(p[i]);
+	}
+}
+
+static void journalNop(void *arg) {}
+
+// journalReplay returns the file events under root since the event since.
+// It waits up to timeout seconds for the end of the history.
+static journal *journalReplay(const char *root, FSEventStreamEventId since, double timeout) {
+	journal *j = calloc(1, sizeof *j);
+	j->sem = dispatch_semaphore_create(0);
+	CFStringRef path = CFStringCreateWithCString(NULL, root, kCFStringEncodingUTF8);
+	CFArrayRef paths = CFArrayCreate(NULL, (const void **)&path, 1, &kCFTypeArrayCallBacks);
+	FSEventStreamContext ctx = {0, j, NULL, NULL, NULL};
+	FSEventStreamRef stream = FSEventStreamCreate(NULL, journalEvents, &ctx, paths, since, 0, kFSEventStreamCreateFlagFileEvents);
+	CFRelease(paths);
+	CFRelease(path);
+	if (stream == NULL) {
+		j->lost = 1;
+		return j;
+	}
+	dispatch_queue_t q = dispatch_queue_create("autocmd.fsevents", DISPATCH_QUEUE_SERIAL);
+	FSEventStreamSetDispatchQueue(stream, q);
+	int ok = 0;
+	if (FSEventStreamStart(stream)) {
+		ok = dispatch_semaphore_wait(j->sem, dispatch_time(DISPATCH_TIME_NOW, (int64_t)(timeout * NSEC_PER_SEC))) == 0;
+		FSEventStreamStop(stream);
+	}
+	FSEventStreamInvalidate(stream);
+	FSEventStreamRelease(stream);
+	// Wait for a callback that might still be running.
+	dispatch_sync_f(q, NULL, journalNop);
+	dispatch_release(q);
+	if (!ok) {
+		j->lost = 1;
+	}
+	return j;
+}
+
+static void journalFree(journal *j) {
+	for (int i = 0; i < j->n; i++) {
+		free(j->paths[i]);
+	}
+	free(j->paths);
+	dispatch_release(j->sem);
+	free(j);
+}
+
+// volumeUUID writes the UUID of the FSEvents database of the device dev to
+// buf.  It returns -1 if there is none.
+static int volumeUUID(dev_t dev, char *buf, int size) {
+	CFUUIDRef uuid = FSEventsCopyUUIDForDevice(dev);
+	if (uuid == NULL) {
+		return -1;
+	}
+	CFStringRef s = CFUUIDCreateString(NULL, uuid);
+	CFRelease(uuid);
+	Boolean ok = CFStringGetCString(s, buf, size, kCFStringEncodingUTF8);
+	CFRelease(s);
+	return ok ? 0 : -1;
+}
+*/
+import "C"
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// journalTimeout is how long to wait for FSEvents to replay its history.
+const journalTimeout = 10 * time.Second
+
+// journalNow returns the current position in the change journal, which on
+// macOS is the most recent FSEvents event ID.
+func journalNow() (uint64, bool) {
+	return uint64(C.FSEventsGetCurrentEventId()), true
+}
+
+// journalVolume returns the identity of the change journal of the volume
+// holding dir.  Positions in one journal mean nothing in another, e.g., after
+// the volume is erased.
+func journalVolume(dir string) (string, bool) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	var buf [64]C.char
+	if C.volumeUUID(C.dev_t(st.Dev), &buf[0], C.int(len(buf))) != 0 {
+		return "", false
+	}
+	return C.GoString(&buf[0]), true
+}
+
+// journalChanges returns the absolute paths of the files and directories
+// under dir, which must be absolute, that changed since the position pos.
+// ok is false if they are not known, e.g., because the history was purged.
+func journalChanges(dir string, pos uint64) (paths []string, ok bool) {
+	cdir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
+	j := C.journalReplay(cdir, C.FSEventStreamEventId(pos), C.double(journalTimeout.Seconds()))
+	defer C.journalFree(j)
+	if j.lost != 0 {
+		return nil, false
+	}
+	for _, p := range unsafe.Slice(j.paths, j.n) {
+		paths = append(paths, C.GoString(p))
+	}
+	return paths, true
+}
@@ -0,0 +1,21 @@
+//go:build !darwin || !cgo
+
+package main
+
+// journalNow returns the current position in the change journal.  There is
+// no change journal on this system.
+func journalNow() (uint64, bool) {
+	return 0, false
+}
+
+// journalVolume returns the identity of the change journal of the volume
+// holding dir.  There is no change journal on this system.
+func journalVolume(dir string) (string, bool) {
+	return "", false
+}
+
+// journalChanges returns the paths under dir that changed since the position
+// pos.  There is no change journal on this system.
+func journalChanges(dir string, pos uint64) (paths []string, ok bool) {
+	return nil, false
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A gitRepo is a git repository whose HEAD or commits are watched.
+type gitRepo struct {
+	dir    string // the git directory, normally .git
+	common string // the directory holding refs, differs from dir in a worktree
+	head   string // the last HEAD seen, as returned by readHead
+}
+
+var gitHead *gitRepo
+
+// findGitDir returns the git directory of the repository containing the
+// current directory.
+func findGitDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, ".git")
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil:
+		case fi.IsDir():
+			return path, nil
+		default:
+			// A worktree or submodule has a .git file that
+			// contains "gitdir: PATH".
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			gdir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !ok {
+				return "", fmt.Errorf("%s: not a git directory", path)
+			}
+			if !filepath.IsAbs(gdir) {
+				gdir = filepath.Join(dir, gdir)
+			}
+			return gdir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("not in a git repository")
+		}
+		dir = parent
+	}
+}
+
+// watchGitHead starts watching HEAD of the current git repository.
+func watchGitHead() error {
+	g, err := openGitRepo()
+	if err != nil {
+		return err
+	}
+	gitHead = g
+	return nil
+}
+
+// openGitRepo returns the git repository containing the current directory.
+func openGitRepo() (*gitRepo, error) {
+	dir, err := findGitDir()
+	if err != nil {
+		return nil, err
+	}
+	g := &gitRepo{dir: dir, common: dir}
+	if data, err := os.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(dir, common)
+		}
+		g.common = common
+	}
+	g.head = g.readHead()
+	return g, nil
+}
+
+// readHead returns the current branch and commit of g in the form
+// BRANCH@COMMIT, or just COMMIT if HEAD is detached.
+func (g *gitRepo) readHead() string {
+	data, err := os.ReadFile(filepath.Join(g.dir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(head, "ref: ")
+	if !ok {
+		return head
+	}
+	return strings.TrimPrefix(ref, "refs/heads/") + "@" + g.resolve(ref)
+}
+
+// resolve returns the commit ref refers to, or the empty string.
+func (g *gitRepo) resolve(ref string) string {
+	if data, err := os.ReadFile(filepath.Join(g.common, ref)); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	fd, err := os.Open(filepath.Join(g.common, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if hash, name, ok := strings.Cut(scanner.Text(), " "); ok && name == ref {
+			return hash
+		}
+	}
+	return ""
+}
+
+// busy returns true if git appears to be in the middle of changing the
+// working tree, e.g., checking out a branch.
+func (g *gitRepo) busy() bool {
+	_, err := os.Stat(filepath.Join(g.dir, "index.lock"))
+	return err == nil
+}
+
+// checkGitHead checks if HEAD has changed, e.g., the branch was switched or
+// a commit was pulled.  If so, all sets are forced to run.  It returns false
+// if git is busy changing the working tree, in which case the caller should
+// not look for changed files until git is done.  This causes all the files
+// changed by a checkout to be seen as a single change.
+func checkGitHead() bool {
+	if gitHead == nil {
+		return true
+	}
+	if gitHead.busy() {
+		return false
+	}
+	head := gitHead.readHead()
+	if head == gitHead.head {
+		return true
+	}
+	printf("%s HEAD changed: %s -> %s\n", now(), shortHead(gitHead.head), shortHead(head))
+	gitHead.head = head
+	for _, s := range allSets() {
+		s.forced = true
+	}
+	return true
+}
+
+// shortHead abbreviates the commit in head, as returned by readHead.
+func shortHead(head string) string {
+	x := strings.LastIndex(head, "@") + 1
+	if len(head)-x > 8 {
+		head = head[:x+8]
+	}
+	return head
+}
+
+// gitDiff is the set of files that differ from the --git-diff ref.  It is
+// computed at most once per pass, when first needed.
+var gitDiff map[string]bool
+
+// resetGitDiff causes gitDiff to be recomputed.  It is called at the start
+// of each pass.
+func resetGitDiff() {
+	gitDiff = nil
+}
+
+// loadGitDiff sets gitDiff to the files, relative to the current directory,
+// that differ from the --git-diff ref, including untracked files.
+func loadGitDiff() {
+	gitDiff = map[string]bool{}
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", flags.GitDiff, "--"},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		out, err := exec.Command("git", args...).Output()
+		if err != nil {
+			warnf("git %s: %v\n", args[0], err)
+			continue
+		}
+		for _, line := range bytes.Split(out, []byte("\n")) {
+			if len(line) > 0 {
+				gitDiff[string(line)] = true
+			}
+		}
+	}
+}
+
+// inGitDiff returns true if path may trigger a command.  With --git-diff only
+// files that differ from the ref may, otherwise all files may.
+func inGitDiff(path string) bool {
+	if flags.GitDiff == "" {
+		return true
+	}
+	if gitDiff == nil {
+		loadGitDiff()
+	}
+	return gitDiff[filepath.Clean(path)]
+}
+
+// gitDiffFiles returns the files that differ from the --git-diff ref that
+// are watched by s, sorted by name.
+func (s *set) gitDiffFiles() []string {
+	if gitDiff == nil {
+		loadGitDiff()
+	}
+	var files []string
+	for path := range s.seen {
+		if gitDiff[filepath.Clean(path)] {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// commitCount is the number of commits that have been seen by checkCommits.
+// Sets with the on=commit option run when it changes.
+var commitCount int
+
+// The reflog of HEAD is used to detect commits.  commitLog is the repository
+// and commitLogSize is how much of its reflog has been read.
+var (
+	commitLog     *gitRepo
+	commitLogSize int64
+	commitWarned  bool
+)
+
+// checkCommits looks for new commits in the reflog of HEAD, incrementing
+// commitCount if there are any.  It does nothing if no set has the on=commit
+// option.
+func checkCommits() {
+	need := false
+	for _, s := range allSets() {
+		need = need || s.onCommit
+	}
+	if !need {
+		return
+	}
+	if commitLog == nil {
+		g, err := openGitRepo()
+		if err != nil {
+			if !commitWarned {
+				warnf("on=commit: %v\n", err)
+				commitWarned = true
+			}
+			return
+		}
+		commitLog = g
+		if fi, err := os.Stat(g.reflog()); err == nil {
+			commitLogSize = fi.Size()
+		}
+		return
+	}
+	fd, err := os.Open(commitLog.reflog())
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	switch {
+	case err != nil, fi.Size() == commitLogSize:
+		return
+	case fi.Size() < commitLogSize:
+		// The reflog was expired or rewritten.
+		commitLogSize = fi.Size()
+		return
+	}
+	fd.Seek(commitLogSize, 0)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := scanner.Text()
+		commitLogSize += int64(len(line)) + 1
+		// Each entry is "OLD NEW WHO WHEN\tMESSAGE" where
+		// MESSAGE is "commit: ...", "commit (amend): ...", etc.
+		_, msg, _ := strings.Cut(line, "\t")
+		if strings.HasPrefix(msg, "commit") {
+			printf("%s New %s\n", now(), msg)
+			commitCount++
+		}
+	}
+}
+
+// reflog returns the path of the reflog of HEAD in g.
+func (g *gitRepo) reflog() string {
+	return filepath.Join(g.dir, "logs", "HEAD")
+}
+
+// A gitSource is a ChangeSource that watches the files git knows about in
+// a directory: the tracked files and the untracked files that are not
+// ignored.  Files ignored by .gitignore are never watched.  The list of
+// files is reread when the index changes, or every --rescan to find new
+// untracked files, and the listed files are stat'ed on each pass.
+type gitSource struct {
+	dir    string
+	index  string      // the path of the index
+	seen   os.FileInfo // the index when the files were listed
+	listed time.Time   // when the files were listed
+	files  []string    // with dir prepended
+}
+
+func newGitSource(dir, arg string) (ChangeSource, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("git takes no argument")
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: not in a git repository", dir)
+	}
+	g := &gitSource{
+		dir:   dir,
+		index: filepath.Join(strings.TrimSpace(string(out)), "index"),
+	}
+	if err := g.list(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// list lists the files git knows about in g.dir.
+func (g *gitSource) list() error {
+	g.seen, _ = os.Stat(g.index)
+	g.listed = now()
+	out, err := exec.Command("git", "-C", g.dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return fmt.Errorf("git ls-files: %v", err)
+	}
+	g.files = g.files[:0]
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			g.files = append(g.files, filepath.Join(g.dir, string(name)))
+		}
+	}
+	return nil
+}
+
+func (g *gitSource) glob(patterns []string, f map[string]os.FileInfo) error {
+	fi, _ := os.Stat(g.index)
+	if fi == nil || g.seen == nil || !SameFile(fi, g.seen) || flags.Rescan <= 0 || now().Sub(g.listed) >= flags.Rescan {
+		if err := g.list(); err != nil {
+			warnf("%v\n", err)
+		}
+	}
+	var elems [][]string
+	for _, p := range patterns {
+		elems = append(elems, strings.Split(filepath.Clean(p), "/"))
+	}
+	var matches []string
+	for _, path := range g.files {
+		pelems := strings.Split(path, "/")
+		for _, e := range elems {
+			if matchElems(e, pelems) {
+				matches = append(matches, path)
+				break
+			}
+		}
+	}
+	statAll(matches, f)
+	return nil
+}
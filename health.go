@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthy runs the --healthcheck once and returns nil if it passes.  A check
+// that starts with http:// or https:// is a URL that must return a 2xx
+// status, otherwise it is a shell command that must exit 0 within
+// --healthcheck-timeout.
+func healthy() error {
+	check := flags.Healthcheck
+	if strings.HasPrefix(check, "http://") || strings.HasPrefix(check, "https://") {
+		client := http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get(check)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", check, resp.Status)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), flags.HealthcheckTimeout)
+	defer cancel()
+	return shellCmd(ctx, check).Run()
+}
+
+// healthCheck repeatedly runs the --healthcheck for the command of j, which
+// was started as described by r, until it passes, the command finishes, or
+// --healthcheck-timeout passes.  A command that does not become healthy in
+// time is reported as a failure, though it is left running.  r is a copy of
+// the result as completed updates the original while the check runs.
+func healthCheck(j *job, r result, finished chan struct{}) {
+	deadline := time.Now().Add(flags.HealthcheckTimeout)
+	for {
+		select {
+		case <-finished:
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+		err := healthy()
+		if err == nil {
+			printf("%s Health check passed\n", now())
+			reloadBrowsers()
+			return
+		}
+		if time.Now().After(deadline) {
+			printf("%s Health check failed: %v\n", now(), err)
+			bell()
+			setTitle("UNHEALTHY " + now().Format("15:04"))
+			r.Error = "health check failed: " + err.Error()
+			writeStatus("unhealthy", &r)
+			ui.setState("unhealthy", &r)
+			return
+		}
+	}
+}
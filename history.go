@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// history holds the last --history completed runs, oldest first.
+var (
+	historyMu sync.Mutex
+	history   []*result
+)
+
+// addHistory records the completed run r in the history and appends it to
+// the --history-file, if any.
+func addHistory(r *result) {
+	if flags.History <= 0 {
+		return
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	history = append(history, r)
+	if len(history) > flags.History {
+		history = append(history[:0], history[len(history)-flags.History:]...)
+	}
+	if flags.HistoryFile == "" {
+		return
+	}
+	data, err := json.Marshal(r.redacted())
+	if err != nil {
+		return
+	}
+	fd, err := os.OpenFile(flags.HistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		warnf("history-file: %v\n", err)
+		return
+	}
+	fd.Write(append(data, '\n'))
+	fd.Close()
+}
+
+// loadHistory reads the last --history runs from the --history-file, if it
+// exists.
+func loadHistory() error {
+	runs, err := readHistoryFile(flags.HistoryFile, flags.History)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	historyMu.Lock()
+	history = runs
+	historyMu.Unlock()
+	return nil
+}
+
+// readHistoryFile returns the last n runs recorded in path.
+func readHistoryFile(path string, n int) ([]*result, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	var runs []*result
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r result
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		runs = append(runs, &r)
+		if len(runs) > n {
+			runs = runs[1:]
+		}
+	}
+	return runs, scanner.Err()
+}
+
+// writeHistory writes runs to w, one run per line, followed by the files
+// that triggered it.
+func writeHistory(w io.Writer, runs []*result) {
+	for _, r := range runs {
+		status := "PASS"
+		switch {
+		case r.Killed:
+			status = "KILLED"
+		case r.ExitCode != 0:
+			status = fmt.Sprintf("FAIL(%d)", r.ExitCode)
+		}
+		d := time.Duration(r.Duration * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(w, "%s %-8s %8v set %s: %s\n", r.Start.Format("2006-01-02 15:04:05"), status, d, r.Set, strings.Join(r.Command, " "))
+		for _, f := range r.Files {
+			fmt.Fprintf(w, "\t%s\n", f)
+		}
+	}
+}
+
+// historyText returns the history as written by writeHistory.
+func historyText() string {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if len(history) == 0 {
+		return "no runs"
+	}
+	var b strings.Builder
+	writeHistory(&b, history)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// showHistory runs the history subcommand.  It asks the running autocmd for
+// its history.  If autocmd is not running the --history-file is read
+// instead.
+func showHistory(w io.Writer) error {
+	resp, err := sendControl("history")
+	if err == nil {
+		fmt.Fprintln(w, resp)
+		return nil
+	}
+	if flags.HistoryFile == "" {
+		return err
+	}
+	runs, ferr := readHistoryFile(flags.HistoryFile, flags.History)
+	if ferr != nil {
+		return ferr
+	}
+	writeHistory(w, runs)
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ignoreFileName is the name of the files that list, using the syntax of
+// .gitignore, files that are not watched.  The rules in such a file apply to
+// the directory containing it and everything below.  Rules in deeper files,
+// and later rules in the same file, take precedence.
+const ignoreFileName = ".autocmdignore"
+
+// An ignoreRule is a single line of an ignore file.
+type ignoreRule struct {
+	elems    []string // the pattern, split at /, with ** replaced by ...
+	negate   bool     // the line started with !
+	dirOnly  bool     // the line ended with /
+	anchored bool     // the pattern contains a / other than at the end
+}
+
+// An ignoreFile holds the rules read from the ignore file in a directory,
+// if any.
+type ignoreFile struct {
+	checked time.Time   // when fi was last checked
+	fi      os.FileInfo // the ignore file when read, or nil
+	rules   []ignoreRule
+}
+
+// ignoreFiles holds the ignore files read so far, by directory.  A file is
+// checked for changes at most once per --frequency.
+var (
+	ignoreMu    sync.Mutex
+	ignoreFiles = map[string]*ignoreFile{}
+)
+
+// passRules and passIgnored cache, for the current pass over the files, the
+// rules of the ignore file in each directory and whether each directory is
+// ignored.  They are reset by newIgnorePass.
+var (
+	passRules   = map[string][]ignoreRule{}
+	passIgnored = map[string]bool{}
+)
+
+// newIgnorePass forgets what was cached during the previous pass.
+func newIgnorePass() {
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+	passRules = map[string][]ignoreRule{}
+	passIgnored = map[string]bool{}
+}
+
+// ignoreRules returns the rules of the ignore file in dir.
+func ignoreRules(dir string) []ignoreRule {
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+	if rules, ok := passRules[dir]; ok {
+		return rules
+	}
+	f := ignoreFiles[dir]
+	t := now()
+	if f != nil && t.Sub(f.checked) < flags.Frequency {
+		passRules[dir] = f.rules
+		return f.rules
+	}
+	if f == nil {
+		f = &ignoreFile{}
+		ignoreFiles[dir] = f
+	}
+	f.checked = t
+	path := filepath.Join(dir, ignoreFileName)
+	fi, err := os.Stat(path)
+	switch {
+	case err != nil:
+		f.fi, f.rules = nil, nil
+	case f.fi == nil || !SameFile(fi, f.fi):
+		f.fi, f.rules = fi, readIgnoreFile(path)
+	}
+	passRules[dir] = f.rules
+	return f.rules
+}
+
+// readIgnoreFile returns the rules in the ignore file path.
+func readIgnoreFile(path string) []ignoreRule {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var r ignoreRule
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			// \# and \! are a literal # and !.
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		r.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		for _, e := range strings.Split(line, "/") {
+			if e == "**" {
+				e = "..."
+			}
+			r.elems = append(r.elems, e)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// match returns true if r matches rel, which is relative to the directory
+// of the ignore file r came from.
+func (r *ignoreRule) match(rel []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := filepath.Match(r.elems[0], rel[len(rel)-1])
+		return ok
+	}
+	return matchElems(r.elems, rel)
+}
+
+// ignoredByFile returns true if path, which is a directory if isDir is
+// true, or any directory containing it, is ignored by an ignore file.
+func ignoredByFile(path string, isDir bool) bool {
+	elems := strings.Split(filepath.Clean(path), "/")
+	if isDir {
+		return dirIgnored(elems)
+	}
+	n := len(elems)
+	return n > 1 && dirIgnored(elems[:n-1]) || ignoredElems(elems, false)
+}
+
+// dirIgnored returns true if the directory made up of elems, or any
+// directory containing it, is ignored by an ignore file.  The result is
+// computed once per pass.
+func dirIgnored(elems []string) bool {
+	key := strings.Join(elems, "/")
+	ignoreMu.Lock()
+	ignored, ok := passIgnored[key]
+	ignoreMu.Unlock()
+	if ok {
+		return ignored
+	}
+	ignored = len(elems) > 1 && dirIgnored(elems[:len(elems)-1]) || ignoredElems(elems, true)
+	ignoreMu.Lock()
+	passIgnored[key] = ignored
+	ignoreMu.Unlock()
+	return ignored
+}
+
+// ignoredElems returns true if the path made up of elems is ignored by the
+// ignore files in the directories containing it, not considering whether
+// those directories are themselves ignored.
+func ignoredElems(elems []string, isDir bool) bool {
+	ignored := false
+	for j := 0; j < len(elems); j++ {
+		var dir string
+		switch {
+		case j > 0:
+			dir = strings.Join(elems[:j], "/")
+		case elems[0] == "":
+			// An absolute path, start at the root.
+			continue
+		default:
+			dir = "."
+		}
+		if dir == "" {
+			dir = "/"
+		}
+		if elems[j] == ".." {
+			continue
+		}
+		for _, r := range ignoreRules(dir) {
+			if r.match(elems[j:], isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A proposal is a set proposed by autocmd init.
+type proposal struct {
+	why  string // what the proposal is based on
+	line string // the set line, without "set: "
+}
+
+// initConfig runs the autocmd init subcommand.  It proposes sets based on
+// the files in the current directory, asks which to use by reading answers
+// from in, and writes the config file.  The config is written to .autocmd
+// unless args names another file.  If in reaches EOF the default answers are
+// used, so
+//
+//	autocmd init < /dev/null
+//
+// accepts all the proposals.
+func initConfig(in io.Reader, out io.Writer, args []string) error {
+	path := ".autocmd"
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		return fmt.Errorf("usage: autocmd init [PATH]")
+	}
+	r := bufio.NewReader(in)
+	if _, err := os.Stat(path); err == nil {
+		if !ask(r, out, fmt.Sprintf("%s exists, replace it?", path), false) {
+			return nil
+		}
+	}
+	proposals := propose()
+	if len(proposals) == 0 {
+		return fmt.Errorf("found nothing to watch (no go.mod, package.json, Cargo.toml, or Makefile)")
+	}
+	var lines []string
+	for _, p := range proposals {
+		fmt.Fprintf(out, "\n%s:\n\tset: %s\n", p.why, p.line)
+		if !ask(r, out, "Use this set?", true) {
+			continue
+		}
+		fmt.Fprintf(out, "Command [%s]: ", p.line[strings.Index(p.line, " -- ")+4:])
+		if cmd := readLine(r, out); cmd != "" {
+			p.line = p.line[:strings.Index(p.line, " -- ")+4] + cmd
+		}
+		lines = append(lines, "set: "+p.line)
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(out, "No sets chosen, nothing written.")
+		return nil
+	}
+	config := "# Written by autocmd init.  See autocmd --help for the syntax.\n" + fmt.Sprintf("version: %d\n", configVersion) + strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nWrote %s.  Run autocmd with no arguments to use it.\n", path)
+	return nil
+}
+
+// ask writes question to out and returns the yes or no answer read from r.
+// def is returned if the answer is empty or cannot be read.
+func ask(r *bufio.Reader, out io.Writer, question string, def bool) bool {
+	choices := " [y/N] "
+	if def {
+		choices = " [Y/n] "
+	}
+	for {
+		fmt.Fprint(out, question+choices)
+		switch strings.ToLower(readLine(r, out)) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// readLine returns the next line read from r, without surrounding white
+// space.  At EOF it writes a newline to out, as the user would have, and
+// returns the empty string.
+func readLine(r *bufio.Reader, out io.Writer) string {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(out)
+	}
+	return strings.TrimSpace(line)
+}
+
+// propose returns the sets proposed for the project in the current
+// directory.
+func propose() []proposal {
+	var proposals []proposal
+	if exists("go.mod") {
+		proposals = append(proposals, proposal{
+			why:  "Go module (go.mod)",
+			line: "name=go .../*.go go.mod go.sum -- go build ./... ++ go test ./...",
+		})
+	}
+	if exists("package.json") {
+		cmd := "npm run build"
+		if scripts := npmScripts(); scripts["test"] {
+			cmd = "npm test"
+		} else if !scripts["build"] {
+			cmd = "npm install"
+		}
+		proposals = append(proposals, proposal{
+			why:  "Node package (package.json)",
+			line: "name=node .../*.js .../*.ts .../*.jsx .../*.tsx package.json -- " + cmd,
+		})
+	}
+	if exists("Cargo.toml") {
+		proposals = append(proposals, proposal{
+			why:  "Rust crate (Cargo.toml)",
+			line: "name=rust .../*.rs Cargo.toml -- cargo test",
+		})
+	}
+	if exists("Makefile") && len(proposals) == 0 {
+		patterns := []string{"Makefile"}
+		for _, ext := range sourceExts(3) {
+			patterns = append(patterns, ".../*"+ext)
+		}
+		proposals = append(proposals, proposal{
+			why:  "Makefile",
+			line: "name=make " + strings.Join(patterns, " ") + " -- make",
+		})
+	}
+	return proposals
+}
+
+// exists returns true if the file path exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// npmScripts returns the names of the scripts in package.json.
+func npmScripts() map[string]bool {
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, _ := os.ReadFile("package.json")
+	json.Unmarshal(data, &pkg)
+	scripts := map[string]bool{}
+	for name := range pkg.Scripts {
+		scripts[name] = true
+	}
+	return scripts
+}
+
+// sourceExts returns up to n of the most common file extensions in the tree
+// rooted at the current directory, skipping the directories ... skips.
+func sourceExts(n int) []string {
+	counts := map[string]int{}
+	for _, dir := range walkDirs(".") {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if ext := filepath.Ext(e.Name()); ext != "" && !e.IsDir() && e.Name()[0] != '.' {
+				counts[ext]++
+			}
+		}
+	}
+	var exts []string
+	for ext := range counts {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if counts[exts[i]] != counts[exts[j]] {
+			return counts[exts[i]] > counts[exts[j]]
+		}
+		return exts[i] < exts[j]
+	})
+	if len(exts) > n {
+		exts = exts[:n]
+	}
+	return exts
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var errKilled = errors.New("job killed")
+
+// credential, when not nil, is the user and groups commands are run as
+// (--user).  userEnv are the environment variables that describe that user.
+var (
+	credential *syscall.Credential
+	userEnv    []string
+)
+
+// An rlimit is a resource limit set on each command.
+type rlimit struct {
+	resource int
+	value    uint64
+}
+
+// limits are the resource limits of commands (--mem-limit and --cpu-limit).
+// Resource limits are inherited, so they also apply to every process the
+// command starts.
+var limits []rlimit
+
+// limitsEnv is the environment variable that tells a copy of autocmd to set
+// the resource limits it describes on itself and then execute its arguments.
+// This is how the limits are set in a command before it runs.
+const limitsEnv = "AUTOCMD_RLIMITS"
+
+// setLimits sets limits to limit the virtual memory of commands to mem bytes
+// and their CPU time to cpu.  A zero value means no limit.  mem must be zero
+// if rlimitAS is negative, i.e., virtual memory cannot be limited.
+func setLimits(mem int64, cpu time.Duration) {
+	if mem > 0 {
+		limits = append(limits, rlimit{rlimitAS, uint64(mem)})
+	}
+	if cpu > 0 {
+		limits = append(limits, rlimit{syscall.RLIMIT_CPU, uint64((cpu + time.Second - 1) / time.Second)})
+	}
+}
+
+// limitsSpec returns the value of limitsEnv that describes limits.
+func limitsSpec() string {
+	var specs []string
+	for _, l := range limits {
+		specs = append(specs, fmt.Sprintf("%d=%d", l.resource, l.value))
+	}
+	return strings.Join(specs, ",")
+}
+
+func init() {
+	if spec, ok := os.LookupEnv(limitsEnv); ok {
+		execLimited(spec, os.Args[1:])
+	}
+}
+
+// execLimited sets the resource limits described by spec on this process
+// and then replaces it with command.  It only returns by exiting.
+func execLimited(spec string, command []string) {
+	os.Unsetenv(limitsEnv)
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "autocmd: %v\n", err)
+		os.Exit(127)
+	}
+	for _, s := range strings.Split(spec, ",") {
+		r, v, _ := strings.Cut(s, "=")
+		resource, err := strconv.Atoi(r)
+		if err != nil {
+			fail(fmt.Errorf("invalid %s: %q", limitsEnv, spec))
+		}
+		value, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			fail(fmt.Errorf("invalid %s: %q", limitsEnv, spec))
+		}
+		if err := setrlimit(resource, value); err != nil {
+			fail(err)
+		}
+	}
+	if len(command) == 0 {
+		fail(fmt.Errorf("%s: no command", limitsEnv))
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		fail(err)
+	}
+	fail(syscall.Exec(path, command, os.Environ()))
+}
+
+// runners are the commands, such as nice, that the shell uses to execute
+// each command (--nice and --ionice).
+var runners []string
+
+// setPriority sets runners to run commands with a niceness of nice and, if
+// idle is set, in the idle I/O scheduling class.
+func setPriority(nice int, idle bool) {
+	if nice != 0 {
+		runners = append(runners, fmt.Sprintf("nice -n %d", nice))
+	}
+	if idle {
+		runners = append(runners, "ionice -c 3")
+	}
+}
+
+// setUser arranges for commands to be run as the user name, which is either
+// a login name or a numeric user ID.
+func setUser(name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return fmt.Errorf("unknown user: %s", name)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s: invalid uid %s", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s: invalid gid %s", name, u.Gid)
+	}
+	if uid == uint64(os.Geteuid()) {
+		// We are already this user.
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("must be root to run commands as %s", name)
+	}
+	c := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	gids, _ := u.GroupIds()
+	for _, g := range gids {
+		if n, err := strconv.ParseUint(g, 10, 32); err == nil {
+			c.Groups = append(c.Groups, uint32(n))
+		}
+	}
+	credential = c
+	userEnv = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+	}
+	return nil
+}
+
+// A job is the running command of a set.  With --per-file a job may consist
+// of several commands.  Each command is started in its own process group so
+// that killing the job also kills everything the commands started.
+type job struct {
+	mu      sync.Mutex
+	running map[*exec.Cmd][]*lineWriter // the output of each command
+	killed  bool
+	onKill  map[*exec.Cmd][]func() // called if the job is killed while cmd runs
+	pending []func()               // atKill functions for the next command
+	stdin   *os.File               // standard input of commands, if not nil
+	stdout  io.Writer              // where commands write their standard output
+	stderr  io.Writer              // where commands write their standard error
+}
+
+// newJob returns a new job whose commands write to cmdStdout and cmdStderr.
+func newJob() *job {
+	return &job{
+		running: map[*exec.Cmd][]*lineWriter{},
+		onKill:  map[*exec.Cmd][]func(){},
+		stdout:  cmdStdout,
+		stderr:  cmdStderr,
+	}
+}
+
+// start starts command as part of j.  The --listen socket is only passed to
+// command if listen is set, as only the last command of a pipeline is the
+// server.  It returns errKilled if j has been killed.
+func (j *job) start(command []string, listen bool) (*exec.Cmd, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.killed {
+		return nil, errKilled
+	}
+	cmd, err := commandCmd(command, listen && listenFile != nil)
+	if err != nil {
+		return nil, err
+	}
+	if j.stdin != nil {
+		cmd.Stdin = j.stdin
+	}
+	return j.launch(cmd)
+}
+
+// startHelper starts command, a helper such as rsync, as part of j.  Unlike
+// start, command is run as the user running autocmd and without the limits,
+// priority, environment, or listening socket given to commands.
+func (j *job) startHelper(command []string) (*exec.Cmd, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.killed {
+		return nil, errKilled
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return j.launch(cmd)
+}
+
+// shellCmd returns the exec.Cmd that runs the shell command command, such as
+// an if= or --after command, with the --env-file variables and no standard
+// input.  command, and anything it started, is killed once ctx is done.
+func shellCmd(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = commandEnv()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// commandCmd returns the exec.Cmd that runs command with the user, limits,
+// priority, and environment given by the flags and, if listen is set, the
+// --listen socket.
+func commandCmd(command []string, listen bool) (*exec.Cmd, error) {
+	if len(runners) > 0 || listen {
+		// The shell replaces itself with command, so $$ is also the
+		// process ID of command.
+		var setup []string
+		if listen {
+			setup = append(setup, "LISTEN_PID=$$", "export LISTEN_PID")
+		}
+		script := strings.Join(append(setup, "exec "+strings.Join(runners, " ")+` "$0" "$@"`), " && ")
+		command = append([]string{"/bin/sh", "-c", script}, command...)
+	}
+	if len(limits) > 0 {
+		// A copy of autocmd sets the limits and then replaces itself
+		// with command.
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		command = append([]string{exe}, command...)
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+	if env := envFileVars(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if credential != nil {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, userEnv...)
+	}
+	if listen {
+		cmd.ExtraFiles = []*os.File{listenFile}
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "LISTEN_FDS=1", "LISTEN_FDNAMES=autocmd")
+	}
+	if len(limits) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, limitsEnv+"="+limitsSpec())
+	}
+	return cmd, nil
+}
+
+// launch connects cmd to the output of j and starts it.  j must
+// be locked.
+func (j *job) launch(cmd *exec.Cmd) (*exec.Cmd, error) {
+	var lines []*lineWriter
+	if flags.Passthrough {
+		cmd.Stdout = j.stdout
+		cmd.Stderr = j.stderr
+	} else {
+		stdout, stderr := &lineWriter{w: j.stdout}, &lineWriter{w: j.stderr}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		lines = []*lineWriter{stdout, stderr}
+		// A process the command leaves running in the
+		// background may hold the output open indefinitely.
+		cmd.WaitDelay = time.Second
+	}
+	onKill := j.pending
+	j.pending = nil
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	j.running[cmd] = lines
+	if onKill != nil {
+		j.onKill[cmd] = onKill
+	}
+	return cmd, nil
+}
+
+// wait waits for cmd, which was started by j.start, to exit.
+func (j *job) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command itself succeeded.
+		err = nil
+	}
+	j.mu.Lock()
+	for _, lw := range j.running[cmd] {
+		lw.flush()
+	}
+	delete(j.running, cmd)
+	delete(j.onKill, cmd)
+	j.mu.Unlock()
+	return err
+}
+
+// kill kills the process groups of all the running commands of j and
+// prevents j from starting any more commands.  The atKill functions of the
+// running commands are called once j is unlocked, as they may be slow.
+func (j *job) kill() {
+	j.mu.Lock()
+	j.killed = true
+	var onKill []func()
+	for cmd := range j.running {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		onKill = append(onKill, j.onKill[cmd]...)
+	}
+	j.onKill = map[*exec.Cmd][]func(){}
+	j.pending = nil
+	j.mu.Unlock()
+	for _, f := range onKill {
+		f()
+	}
+}
+
+// atKill arranges for f to be called if j is killed while the next command
+// started by j is running.  This is used to kill processes that are not in
+// the process group of the command.
+func (j *job) atKill(f func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pending = append(j.pending, f)
+}
+
+// wasKilled returns true if j was killed.
+func (j *job) wasKilled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.killed
+}
+
+// pid returns the process ID of one of the running commands of j, or 0 if
+// none are running.
+func (j *job) pid() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for cmd := range j.running {
+		return cmd.Process.Pid
+	}
+	return 0
+}
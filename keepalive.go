@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// keepAliveBuffer is how many lines may be waiting to be written to the
+// standard input of a --keep-alive command.
+const keepAliveBuffer = 100
+
+// errNotReading is returned by notify when the command is not reading its
+// standard input.
+var errNotReading = errors.New("command is not reading its input")
+
+// A keepAlive is a command started with --keep-alive.  The command is not
+// restarted when files change.  Instead a line listing the files that were
+// added or changed is written to its standard input.
+type keepAlive struct {
+	j      *job
+	stdin  *os.File
+	lines  chan string   // lines waiting to be written to stdin
+	failed chan struct{} // closed when writing to stdin fails
+	done   chan struct{} // closed when the command exits
+}
+
+// exited returns true if the command of k has exited.
+func (k *keepAlive) exited() bool {
+	select {
+	case <-k.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// notify queues files, separated by spaces, as a single line to be written
+// to the standard input of k.  It does not block.  It returns errNotReading
+// if an earlier line could not be written or keepAliveBuffer lines are
+// already waiting.
+func (k *keepAlive) notify(files []string) error {
+	select {
+	case <-k.failed:
+		return errNotReading
+	default:
+	}
+	select {
+	case k.lines <- strings.Join(files, " ") + "\n":
+		return nil
+	default:
+		return errNotReading
+	}
+}
+
+// feed writes the lines queued by notify to the standard input of k until
+// the command exits or a write fails.
+func (k *keepAlive) feed() {
+	for {
+		select {
+		case <-k.done:
+			return
+		case line := <-k.lines:
+			if _, err := io.WriteString(k.stdin, line); err != nil {
+				close(k.failed)
+				return
+			}
+		}
+	}
+}
+
+// startKeepAlive starts command for s with --keep-alive.  r describes the
+// run.  If command is a pipeline, the commands before the last are run
+// first, as usual, and only the last is kept alive.
+func (s *set) startKeepAlive(command []string, r *result) error {
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	var setup []string
+	for x := len(command) - 1; x >= 0; x-- {
+		if command[x] == "++" {
+			setup, command = command[:x], command[x+1:]
+			break
+		}
+	}
+	j := newJob()
+	j.stdout, j.stderr = s.output()
+	k := &keepAlive{
+		j:      j,
+		stdin:  wr,
+		lines:  make(chan string, keepAliveBuffer),
+		failed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.alive = k
+	go k.feed()
+	go func() {
+		var cmd *exec.Cmd
+		var err error
+		if setup != nil {
+			err = runSteps(j, setup, false)
+		}
+		if err == nil {
+			j.mu.Lock()
+			j.stdin = rd
+			j.mu.Unlock()
+			cmd, err = j.start(targetCommand(j, command), true)
+		}
+		rd.Close()
+		if err == nil {
+			err = j.wait(cmd)
+		}
+		wr.Close()
+		if err != nil {
+			printf("%sCommand died with %v\n", s.label(), err)
+		} else {
+			printf("%sCommand exited\n", s.label())
+		}
+		completed(r, j, err)
+		close(k.done)
+	}()
+	return nil
+}
+
+// runKeepAlive is run for s with --keep-alive.  It notifies the command of
+// s of files if it is running, otherwise it starts the command.
+func (s *set) runKeepAlive(command, files []string, r *result) {
+	if s.alive != nil && !s.alive.exited() {
+		printf("%s Notifying %s%s\n", now(), s.label(), strings.Join(files, " "))
+		if err := s.alive.notify(files); err == nil {
+			return
+		}
+		// The command is not reading its input, start over.
+		stopKeepAlive(s)
+	}
+	printf("%s Starting %s%s\n", now(), s.label(), command)
+	started(r)
+	if err := s.startKeepAlive(command, r); err != nil {
+		warnf("%v\n", err)
+		completed(r, newJob(), err)
+	}
+}
+
+// stopKeepAlive kills the --keep-alive commands of sets, or of all sets if
+// none are specified, and waits for them to exit.
+func stopKeepAlive(sets ...*set) {
+	if len(sets) == 0 {
+		sets = allSets()
+	}
+	for _, s := range sets {
+		if k := s.alive; k != nil {
+			k.j.kill()
+			<-k.done
+			s.alive = nil
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenFile is the listening socket opened by --listen.  It is passed to
+// each command as file descriptor 3 using the systemd socket activation
+// protocol.  Since autocmd holds the socket open, connections made while a
+// server is being restarted wait to be accepted rather than being refused.
+var listenFile *os.File
+
+// openListener opens the --listen socket on addr, e.g., ":8080".
+func openListener(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("%s: not a TCP address", addr)
+	}
+	// File returns a duplicate of the socket, which is what we pass
+	// to commands.  The listener itself is never used to accept.
+	f, err := tl.File()
+	if err != nil {
+		return err
+	}
+	listenFile = f
+	return nil
+}
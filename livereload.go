@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// reloadScript is served as /livereload.js.  Including it in a page causes
+// the page to reload when autocmd sends a reload event.
+const reloadScript = `(function() {
+	var es = new EventSource(%q);
+	es.addEventListener("reload", function() { location.reload(); });
+})();
+`
+
+// The browsers connected to the --livereload server.  Each is sent a value
+// on its channel when it should reload.
+var (
+	reloadMu      sync.Mutex
+	reloadClients = map[chan struct{}]bool{}
+)
+
+// startLiveReload starts the --livereload server on addr.  It serves a
+// stream of server-sent events on /events and a script that reloads the page
+// on each event on /livereload.js.
+func startLiveReload(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		fmt.Fprintf(w, reloadScript, "//"+req.Host+"/events")
+	})
+	mux.HandleFunc("/events", serveEvents)
+	go func() {
+		err := http.Serve(l, mux)
+		warnf("--livereload: %v\n", err)
+	}()
+	return nil
+}
+
+// serveEvents sends a reload event to the client each time reloadBrowsers
+// is called until the client goes away.
+func serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	flusher.Flush()
+
+	c := make(chan struct{}, 1)
+	reloadMu.Lock()
+	reloadClients[c] = true
+	reloadMu.Unlock()
+	defer func() {
+		reloadMu.Lock()
+		delete(reloadClients, c)
+		reloadMu.Unlock()
+	}()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-c:
+			fmt.Fprint(w, "event: reload\ndata: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// reloadBrowsers tells the connected browsers to reload.
+func reloadBrowsers() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	for c := range reloadClients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockFile is held, with flock, for as long as autocmd runs so that only
+// one autocmd runs in a directory at a time.  Two autocmds watching the
+// same files race each other to build them.
+var lockFile *os.File
+
+// lockPath returns the path of the lock file for the current directory,
+// which is next to the control socket.
+func lockPath() string {
+	return strings.TrimSuffix(socketPath(), ".sock") + ".lock"
+}
+
+// lock takes the lock for the current directory.  It returns an error
+// naming the process that holds the lock if another autocmd is running in
+// the current directory.  The lock file may be in a shared directory, such
+// as /tmp, so it must not be a symbolic link and must be a regular file
+// owned by the user.
+func lock() error {
+	path := lockPath()
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(fd.Fd()), &st); err != nil {
+		fd.Close()
+		return err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFREG || int(st.Uid) != os.Getuid() {
+		fd.Close()
+		return fmt.Errorf("%s is not a file owned by this user", path)
+	}
+	if err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		data := make([]byte, 32)
+		n, _ := fd.Read(data)
+		fd.Close()
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n]))); err == nil {
+			return fmt.Errorf("autocmd (pid %d) is already running in this directory", pid)
+		}
+		return fmt.Errorf("autocmd is already running in this directory")
+	}
+	fd.Truncate(0)
+	fmt.Fprintf(fd, "%d\n", os.Getpid())
+	lockFile = fd
+	return nil
+}
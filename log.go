@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logLevel is the level of messages that are logged.  printf logs at
+// slog.LevelInfo, vprintf at slog.LevelDebug, and warnf at slog.LevelWarn.
+var logLevel = new(slog.LevelVar)
+
+// logger is where autocmd sends its messages.  Until setLogging is called
+// messages are written, as is, to stdout.
+var logger = slog.New(&consoleHandler{level: logLevel})
+
+// setLogging sets the level of messages logged, the format they are written
+// in (console, text, or json), and the file they are written to.  Messages
+// are written to stdout if file is "".
+func setLogging(level, format, file string) error {
+	if level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level: %q", level)
+		}
+	}
+	var w io.Writer
+	if file != "" {
+		fd, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		w = fd
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "", "console":
+		logger = slog.New(&consoleHandler{w: w, level: logLevel})
+	case "text":
+		logger = slog.New(slog.NewTextHandler(stdoutOr(w), opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(stdoutOr(w), opts))
+	default:
+		return fmt.Errorf("invalid log format: %q", format)
+	}
+	return nil
+}
+
+// stdoutOr returns w, or, if w is nil, a writer that writes to whatever
+// stdout is at the time.
+func stdoutOr(w io.Writer) io.Writer {
+	if w != nil {
+		return w
+	}
+	return writerFunc(func(p []byte) (int, error) { return stdout.Write(p) })
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// logf logs the message f, formatted with v, at level.  Messages are
+// formatted as lines, as they always have been, so the trailing newline is
+// removed.  Secrets are redacted.
+func logf(level slog.Level, f string, v ...interface{}) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, redact(strings.TrimSuffix(fmt.Sprintf(f, v...), "\n")))
+}
+
+// warnf logs a problem that does not stop autocmd, e.g., failing to write
+// the --status-file.
+func warnf(f string, v ...interface{}) {
+	logf(slog.LevelWarn, f, v...)
+}
+
+// A consoleHandler is the slog.Handler for people.  It writes just the
+// message of each record, one per line, to w, each line preceded by the
+// time in the --timestamps format, if any.  If w is nil, warnings and
+// errors are written to stderr and everything else to stdout.  Attributes
+// are ignored.
+type consoleHandler struct {
+	w     io.Writer
+	level slog.Leveler
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	// Messages are written a line at a time, like the output of
+	// commands, see lineWriter.
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	w := h.w
+	if w == nil {
+		eraseStatus()
+		w = stdout
+		if r.Level >= slog.LevelWarn {
+			w = stderr
+		}
+	}
+	msg := r.Message
+	if flags.Timestamps != "" {
+		stamp := r.Time.Format(flags.Timestamps) + " "
+		msg = stamp + strings.ReplaceAll(msg, "\n", "\n"+stamp)
+	}
+	writeAttached([]byte(msg + "\n"))
+	_, err := io.WriteString(w, msg+"\n")
+	return err
+}
+
+func (h *consoleHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *consoleHandler) WithGroup(string) slog.Handler      { return h }
@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// noteStart records that s started running at t, which also starts the
+// next period of its timerSource.  If s has been running
+// more than --loop-rate times a minute for the last --loop-time it is
+// probably triggering itself, e.g., its command writes one of its files,
+// so it is paused until it is triggered by hand.
+func (s *set) noteStart(t time.Time) {
+	if ts := s.timer(); ts != nil {
+		ts.last = t
+	}
+	if flags.LoopRate <= 0 || flags.LoopTime <= 0 {
+		return
+	}
+	s.starts = append(s.starts, t)
+	for len(s.starts) > 0 && t.Sub(s.starts[0]) >= flags.LoopTime {
+		s.starts = s.starts[1:]
+	}
+	// Count the starts in each minute, most recent first.
+	minutes := int((flags.LoopTime + time.Minute - 1) / time.Minute)
+	counts := make([]int, minutes)
+	for _, st := range s.starts {
+		if m := int(t.Sub(st) / time.Minute); m < minutes {
+			counts[m]++
+		}
+	}
+	for _, n := range counts {
+		if n <= flags.LoopRate {
+			return
+		}
+	}
+	s.looping = true
+	s.starts = nil
+	bell()
+	warnf("set %s: ran more than %d times a minute for %v, pausing it\n", s, flags.LoopRate, flags.LoopTime)
+	warnf("set %s: if its command changes its own files see produces=, --output, and --ignore-own-output\n", s)
+	warnf("set %s: autocmd --trigger %s, SIGUSR1, or r in --tui runs it again\n", s, s)
+}
+
+// resumeLoop resumes s if it was paused by noteStart.
+func (s *set) resumeLoop() {
+	if s.looping {
+		s.looping = false
+		printf("%s Resuming set %s\n", now(), s)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// configCommand runs the autocmd config subcommand.  The only subcommand is
+// migrate.
+func configCommand(out io.Writer, args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return fmt.Errorf("usage: autocmd config migrate [PATH]")
+	}
+	// The config is found the same way as when autocmd runs.
+	path := flags.Config
+	switch len(args) {
+	case 1:
+		if _, err := os.Stat(".autocmd"); err == nil && !getopt.IsSet("config") {
+			path = ".autocmd"
+		}
+		if path == "" {
+			return fmt.Errorf("no config file")
+		}
+	case 2:
+		path = args[1]
+	default:
+		return fmt.Errorf("usage: autocmd config migrate [PATH]")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	migrated, notes := migrateConfig(string(data))
+	if migrated == string(data) {
+		fmt.Fprintf(out, "%s is already version %d\n", path, configVersion)
+		return nil
+	}
+	if err := os.WriteFile(path+".bak", data, 0644); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(migrated), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	for _, note := range notes {
+		fmt.Fprintf(out, "%s:%s\n", path, note)
+	}
+	fmt.Fprintf(out, "Migrated %s to version %d, the original is in %s.bak\n", path, configVersion, path)
+	return nil
+}
+
+// migrateConfig returns config upgraded to the current version, along with
+// notes, of the form LINE: NOTE, on what was changed.  A version line is
+// added before the first directive or section header, or an older one is
+// updated.  Lines that version 0 ignored, which would be errors now, are
+// commented out so the intent is not lost.  Nothing else changed meaning
+// since version 1.  Included files are not migrated.
+func migrateConfig(config string) (string, []string) {
+	lines := strings.SplitAfter(config, "\n")
+	var notes []string
+	var out []string
+	versioned := false
+	for n, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			out = append(out, line)
+			continue
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		name = strings.TrimSpace(name)
+		if !versioned {
+			// The version must not be in a section.
+			versioned = true
+			if name != "version" {
+				out = append(out, fmt.Sprintf("version: %d\n", configVersion))
+			}
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(value)); name == "version" && err == nil && v < configVersion {
+			notes = append(notes, fmt.Sprintf("%d: updated the version from %d to %d", n+1, v, configVersion))
+			line = fmt.Sprintf("version: %d\n", configVersion)
+		}
+		section := trimmed[0] == '[' && trimmed[len(trimmed)-1] == ']'
+		if !section && (!ok || !knownDirective(name)) {
+			notes = append(notes, fmt.Sprintf("%d: commented out %q, which was ignored", n+1, trimmed))
+			line = "# " + line
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, ""), notes
+}
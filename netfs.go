@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// setupNetworkFS adjusts the defaults for --network-fs.  Polling a network
+// file system is slow, so the default --frequency is 2 seconds, and servers
+// often record times coarsely, so the default --mtime-granularity is 2
+// seconds.
+func setupNetworkFS() {
+	if !flags.NetworkFS {
+		return
+	}
+	if !getopt.IsSet("frequency") {
+		flags.Frequency = 2 * time.Second
+	}
+	if !getopt.IsSet("mtime-granularity") {
+		flags.MtimeGranularity = 2 * time.Second
+	}
+}
+
+// statFailed holds the paths that could not be stat'ed for a reason other
+// than not existing, e.g., ESTALE on NFS.  With --network-fs these files are
+// assumed to be unchanged rather than removed.
+var (
+	statMu     sync.Mutex
+	statFailed = map[string]bool{}
+)
+
+// stat returns os.Stat(path).  With --network-fs a failed stat is retried a
+// few times, and if it still fails for any reason other than path not
+// existing, path is recorded in statFailed.
+func stat(path string) (os.FileInfo, error) {
+	fi, err := os.Stat(path)
+	if !flags.NetworkFS {
+		return fi, err
+	}
+	for i := 0; err != nil && !errors.Is(err, os.ErrNotExist) && i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		fi, err = os.Stat(path)
+	}
+	failed := err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR)
+	statMu.Lock()
+	if failed {
+		statFailed[path] = true
+	} else {
+		delete(statFailed, path)
+	}
+	statMu.Unlock()
+	return fi, err
+}
+
+// unstatable returns true if path could not be stat'ed, see statFailed.
+func unstatable(path string) bool {
+	statMu.Lock()
+	defer statMu.Unlock()
+	return statFailed[path]
+}
+
+// sameContents is called with --network-fs when path, described by f1 and
+// previously by f2, appears to have changed.  It returns true if the size of
+// the file is unchanged and its contents hash to the same value as the last
+// time this was checked, in which case the change is spurious.
+func (s *set) sameContents(path string, f1, f2 os.FileInfo) bool {
+	if !flags.NetworkFS || f1.Size() != f2.Size() || isRemote(path) {
+		return false
+	}
+	old, known := s.hashes[path]
+	return s.hash(path) && known && s.hashes[path] == old
+}
+
+// firstSeen is called when path is first seen.  With --network-fs it hashes
+// path so that even the first spurious change to path is recognized.
+func (s *set) firstSeen(path string) {
+	if flags.NetworkFS && !isRemote(path) {
+		s.hash(path)
+	}
+}
+
+// hash records the hash of the contents of path in s.hashes.  It returns
+// false if path could not be read.
+func (s *set) hash(path string) bool {
+	h, ok := fileHash(path)
+	if !ok {
+		return false
+	}
+	if s.hashes == nil {
+		s.hashes = map[string]uint64{}
+	}
+	s.hashes[path] = h
+	return true
+}
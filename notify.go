@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A result describes a completed run of a set's command.
+type result struct {
+	Set      string    `json:"set"`
+	Command  []string  `json:"command"`
+	Files    []string  `json:"files"` // as returned by changedFiles
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"` // in seconds
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Killed   bool      `json:"killed,omitempty"`
+}
+
+// started is called when the command described by r is started.
+func started(r *result) {
+	setTitle("running " + strings.Join(r.Command, " ") + "…")
+	writeStatus("running", r)
+	ui.setState("running", r)
+	spinRun(r)
+	watchSlow(r)
+}
+
+// completed is called when the command described by r, run as j, has
+// completed.  err is the reason the command failed, or nil.
+func completed(r *result, j *job, err error) {
+	stopSpin(r)
+	stopSlow(r)
+	r.Duration = now().Sub(r.Start).Seconds()
+	r.Killed = j.wasKilled()
+	if err != nil {
+		r.Error = err.Error()
+		r.ExitCode = 1
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			r.ExitCode = ee.ExitCode() // -1 if killed by a signal
+		}
+	}
+	switch {
+	case r.Killed:
+		writeStatus("killed", r)
+		ui.setState("killed", r)
+	case err != nil:
+		bell()
+		setTitle("FAIL " + now().Format("15:04"))
+		writeStatus("fail", r)
+		ui.setState("fail", r)
+	default:
+		setTitle("PASS " + now().Format("15:04"))
+		writeStatus("pass", r)
+		ui.setState("pass", r)
+		reloadBrowsers()
+	}
+	if flags.Webhook != "" {
+		go webhook(flags.Webhook, r)
+	}
+	addHistory(r)
+}
+
+// bellTimeout is how long the --bell-cmd may run.
+const bellTimeout = 10 * time.Second
+
+// bell rings the bell, or starts the --bell-cmd, if requested.  It is not
+// affected by --silent.  The --bell-cmd is run in the background so a slow
+// command does not hold up autocmd, and is killed after bellTimeout.
+func bell() {
+	switch {
+	case flags.BellCmd != "":
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), bellTimeout)
+			defer cancel()
+			cmd := shellCmd(ctx, flags.BellCmd)
+			cmd.Stdout = stdout
+			cmd.Stderr = os.Stderr
+			cmd.Run()
+		}()
+	case flags.Bell:
+		os.Stdout.Write([]byte("\a"))
+	}
+}
+
+// setTitle sets the title of the terminal, and the tmux window name when
+// running in tmux, to "autocmd: " followed by status, if --title was
+// specified.
+func setTitle(status string) {
+	if !flags.Title {
+		return
+	}
+	title := "autocmd: " + status
+	fmt.Fprintf(os.Stdout, "\033]0;%s\007", title)
+	if os.Getenv("TMUX") != "" {
+		fmt.Fprintf(os.Stdout, "\033k%s\033\\", title)
+	}
+}
+
+// writeStatus writes the line
+//
+//	state=STATE exit=CODE time=TIME set=SET
+//
+// to the --status-file, if specified.  The exit code is that of the last
+// completed run, or - if nothing has completed yet.  r describes the run
+// and is nil if there is none.  The file is replaced atomically so readers
+// never see a partial line.
+func writeStatus(state string, r *result) {
+	if flags.StatusFile == "" {
+		return
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	set := "-"
+	if r != nil {
+		set = r.Set
+		if state != "running" {
+			lastExit = fmt.Sprint(r.ExitCode)
+		}
+	}
+	line := fmt.Sprintf("state=%s exit=%s time=%s set=%s\n", state, lastExit, now().Format(time.RFC3339), set)
+	tmp := flags.StatusFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(line), 0644); err != nil {
+		warnf("status-file: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, flags.StatusFile); err != nil {
+		warnf("status-file: %v\n", err)
+	}
+}
+
+var (
+	statusMu sync.Mutex
+	lastExit = "-" // exit code of the last completed run
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook posts r, with secrets redacted, as JSON, to url.
+func webhook(url string, r *result) {
+	data, err := json.Marshal(r.redacted())
+	if err != nil {
+		warnf("webhook: %v\n", err)
+		return
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		warnf("webhook: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		warnf("webhook: %s: %s\n", url, resp.Status)
+	}
+}
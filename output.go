@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// stdout and stderr are where autocmd writes its output and errors.
+// cmdStdout and cmdStderr are where the commands autocmd runs write theirs.
+var (
+	stdout    io.Writer = os.Stdout
+	stderr    io.Writer = os.Stderr
+	cmdStdout io.Writer = os.Stdout
+	cmdStderr io.Writer = os.Stderr
+)
+
+// prefixColors are the colors, as SGR parameters, that tell the output of
+// sets apart with --prefix.
+var prefixColors = []string{"36", "33", "32", "35", "34", "31"}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorOutput returns true if autocmd's output is to a terminal and colors
+// have not been disabled with $NO_COLOR.
+func colorOutput() bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+}
+
+// output returns where the commands of s write their standard output and
+// standard error.  With --tui that is the pane of s.  Otherwise, with
+// --prefix, each line is preceded by the name of s, padded to the length of
+// the longest name, and a |, in a color particular to s if autocmd's output
+// is to a terminal.
+func (s *set) output() (stdout, stderr io.Writer) {
+	if ui != nil {
+		w := ui.setOutput(s)
+		if flags.TimestampOutput {
+			w = &prefixWriter{w: w, prefix: timestamp}
+		}
+		return w, w
+	}
+	if !flags.Prefix {
+		return cmdStdout, cmdStderr
+	}
+	width, index := 0, 0
+	for i, s2 := range allSets() {
+		if n := len(s2.String()); n > width {
+			width = n
+		}
+		if s2 == s {
+			index = i
+		}
+	}
+	label := fmt.Sprintf("%-*s | ", width, s)
+	if colorOutput() {
+		label = "\033[" + prefixColors[index%len(prefixColors)] + "m" + label + "\033[0m"
+	}
+	prefix := func() string { return label }
+	stdout = &prefixWriter{w: cmdStdout, prefix: prefix}
+	stderr = &prefixWriter{w: cmdStderr, prefix: prefix}
+	return stdout, stderr
+}
+
+// settle is called when a command completes.  With --lazy-clear it makes
+// sure the screen has been cleared.
+var settle = func() {}
+
+// maxScreen is the most output a screen remembers.
+const maxScreen = 256 << 10
+
+// clearScreen is the sequence that clears the terminal and its scrollback.
+const clearScreen = "\033[H\033[2J\033[3J"
+
+// A screen is a writer to the terminal that remembers what has been written
+// since the terminal was last cleared so it can be redrawn.
+//
+// A screen may also be cleared lazily.  The screen is not cleared until a
+// command writes to it, in the meantime anything autocmd writes is held
+// until the screen is cleared.
+//
+// Unless the screen is cleared lazily, commands write directly to the
+// terminal rather than through the screen, so that they still see a
+// terminal.  The screen then only remembers what autocmd wrote.
+type screen struct {
+	mu     sync.Mutex
+	w      io.Writer
+	buf    []byte
+	armed  bool   // clear when a command writes
+	held   []byte // what autocmd wrote while armed
+	direct bool   // commands write directly to the terminal
+}
+
+// Write writes p, which is output from autocmd, to s.
+func (s *screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.armed {
+		s.held = append(s.held, p...)
+		return len(p), nil
+	}
+	return s.write(p)
+}
+
+// output returns a writer for the output of commands to s.
+func (s *screen) output() io.Writer {
+	return cmdWriter{s}
+}
+
+type cmdWriter struct{ s *screen }
+
+// outputMu serializes the lines written by lineWriters so that lines from
+// different commands, or from the standard output and standard error of a
+// command, are never interleaved.
+var outputMu sync.Mutex
+
+// maxLine is the longest partial line a lineWriter holds.
+const maxLine = 64 << 10
+
+// A lineWriter writes only whole lines to w.  A partial line is held until
+// it is completed, it exceeds maxLine, or flush is called.  Commands write
+// to lineWriters unless --passthrough is set.
+type lineWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	lw.buf = append(lw.buf, p...)
+	x := bytes.LastIndexByte(lw.buf, '\n')
+	if x < 0 {
+		if len(lw.buf) < maxLine {
+			return len(p), nil
+		}
+		x = len(lw.buf) - 1
+	}
+	eraseStatus()
+	writeAttached(lw.buf[:x+1])
+	_, err := lw.w.Write(lw.buf[:x+1])
+	lw.buf = append(lw.buf[:0], lw.buf[x+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes any partial line held by lw, ending it so what is written
+// next starts on a line of its own.
+func (lw *lineWriter) flush() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if len(lw.buf) > 0 {
+		eraseStatus()
+		lw.buf = append(lw.buf, '\n')
+		writeAttached(lw.buf)
+		lw.w.Write(lw.buf)
+		lw.buf = lw.buf[:0]
+	}
+}
+
+// A prefixWriter writes each line written to it to w preceded by the string
+// returned by prefix.  prefix is called when the first byte of the line is
+// written.  Partial lines are written as they are written, not held until
+// they are complete.
+type prefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  func() string
+	partial bool // the last write did not end a line
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	n := len(p)
+	var buf []byte
+	for len(p) > 0 {
+		if !pw.partial {
+			buf = append(buf, pw.prefix()...)
+		}
+		x := bytes.IndexByte(p, '\n')
+		if x < 0 {
+			buf = append(buf, p...)
+			pw.partial = true
+			break
+		}
+		buf = append(buf, p[:x+1]...)
+		p = p[x+1:]
+		pw.partial = false
+	}
+	if _, err := pw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c cmdWriter) Write(p []byte) (int, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	c.s.disarm()
+	return c.s.write(p)
+}
+
+// write writes p to the terminal, remembering it.  s must be locked.
+func (s *screen) write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	if len(s.buf) > maxScreen {
+		b := s.buf[len(s.buf)-maxScreen:]
+		if x := bytes.IndexByte(b, '\n'); x >= 0 {
+			b = b[x+1:]
+		}
+		s.buf = append(s.buf[:0], b...)
+	}
+	return s.w.Write(p)
+}
+
+// clear clears the terminal and forgets what was written to it.
+func (s *screen) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = s.buf[:0]
+	io.WriteString(s.w, clearScreen)
+}
+
+// clearLazily arranges for s to be cleared when a command first writes to
+// it, or settle is called.
+func (s *screen) clearLazily() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.armed = true
+	s.held = s.held[:0]
+}
+
+// settle clears s if it is waiting to be cleared.
+func (s *screen) settle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disarm()
+}
+
+// disarm clears s, and then writes what was held, if s is waiting to be
+// cleared.  s must be locked.
+func (s *screen) disarm() {
+	if !s.armed {
+		return
+	}
+	s.armed = false
+	s.buf = s.buf[:0]
+	io.WriteString(s.w, clearScreen)
+	s.write(s.held)
+}
+
+// redraw clears the terminal and rewrites what was written to it since it
+// was last cleared.  If commands write directly to the terminal their output
+// cannot be redrawn, so the terminal is not cleared and only what autocmd
+// wrote is written again.
+func (s *screen) redraw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.direct {
+		io.WriteString(s.w, clearScreen)
+	}
+	s.w.Write(s.buf)
+}
+
+// redrawOnResize redraws s each time the terminal is resized.  Resizing
+// tends to generate a burst of signals so we wait for them to settle first.
+func (s *screen) redrawOnResize() {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			for settled := false; !settled; {
+				select {
+				case <-winch:
+				case <-time.After(100 * time.Millisecond):
+					settled = true
+				}
+			}
+			s.redraw()
+		}
+	}()
+}
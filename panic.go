@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// A panic while checking for changes or starting a command, e.g., due to a
+// race with files being removed, is reported and autocmd carries on with
+// the next pass.  Losing a long running autocmd to a transient problem is
+// worse than missing a pass.
+
+// reportPanic reports the panic v that happened while doing what.
+func reportPanic(what string, v interface{}) {
+	warnf("%s Panic while %s, skipping: %v\n", now(), what, v)
+	vprintf("%s\n", debug.Stack())
+}
+
+// changedSets checks the sets that are due at tick, whose check interval is
+// interval, for changes and returns those that need to run.  ok is false
+// if checking panicked.
+func changedSets(tick time.Time, interval time.Duration) (next []*set, ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			reportPanic("checking for changes", v)
+			next, ok = nil, false
+		}
+	}()
+	for _, s := range allSets() {
+		if s.forced {
+			s.resumeLoop()
+		} else if s.looping || !s.due(tick, interval) {
+			continue
+		}
+		if !s.changed(tick) && !s.forced {
+			continue
+		}
+		if !s.forced && !s.open() {
+			s.reasons = nil
+			continue
+		}
+		s.forced = false
+		next = append(next, s)
+	}
+	return next, true
+}
+
+// runSet runs the command of s as s.run does.  If starting the command
+// panics the returned job is nil and finished is closed.
+func runSet(s *set) (cmd *job, finished chan struct{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			reportPanic("running set "+s.String(), v)
+			cmd, finished = nil, make(chan struct{})
+			close(finished)
+		}
+	}()
+	return s.run()
+}
+
+// recoverRun is deferred by the goroutines that run the command of s.  A
+// panic is reported and r is completed as having failed, so the main loop
+// is not left waiting for finished forever.
+func recoverRun(s *set, r *result, j *job, finished chan struct{}) {
+	if v := recover(); v != nil {
+		reportPanic("running set "+s.String(), v)
+		completed(r, j, fmt.Errorf("panic: %v", v))
+		select {
+		case <-finished:
+		default:
+			close(finished)
+		}
+	}
+}
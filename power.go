@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// held is why commands are being held back by --max-load or --on-battery,
+// or "" if they are not.
+var held string
+
+// holdRuns returns true if commands should not be started now because the
+// load average is above --max-load or, with --on-battery=delay or skip, the
+// computer is running on battery.  skip is true if the commands waiting to
+// run should be dropped rather than run once the condition clears.  It must
+// only be called from the main loop.
+func holdRuns() (hold, skip bool) {
+	why := ""
+	if flags.OnBattery != "run" && onBattery() {
+		why = "on battery"
+		skip = flags.OnBattery == "skip"
+	} else if flags.MaxLoad > 0 {
+		if load, ok := loadAverage(); ok && load > flags.MaxLoad {
+			why = fmt.Sprintf("load average %.2f is above %g", load, flags.MaxLoad)
+		}
+	}
+	switch {
+	case why == "" && held != "":
+		printf("%s Running delayed commands\n", now())
+	case skip:
+		printf("%s Skipping commands, %s\n", now(), why)
+	case why != "" && held == "":
+		printf("%s Delaying commands, %s\n", now(), why)
+	}
+	held = why
+	if skip {
+		held = ""
+	}
+	return why != "", skip
+}
+
+// loadAverage returns the one minute load average.  ok is false if it is not
+// known.
+func loadAverage() (load float64, ok bool) {
+	var s string
+	switch runtime.GOOS {
+	case "linux":
+		data, err := os.ReadFile("/proc/loadavg")
+		if err != nil {
+			return 0, false
+		}
+		s = string(data)
+	case "darwin", "freebsd", "openbsd", "netbsd":
+		// { 1.23 1.10 1.00 }
+		out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+		if err != nil {
+			return 0, false
+		}
+		s = strings.Trim(strings.TrimSpace(string(out)), "{ }")
+	default:
+		return 0, false
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+// onBattery returns true if the computer is known to be running on battery.
+func onBattery() bool {
+	switch runtime.GOOS {
+	case "linux":
+		supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+		discharging := false
+		for _, dir := range supplies {
+			kind, _ := os.ReadFile(filepath.Join(dir, "type"))
+			switch strings.TrimSpace(string(kind)) {
+			case "Mains":
+				if online, _ := os.ReadFile(filepath.Join(dir, "online")); strings.TrimSpace(string(online)) == "1" {
+					return false
+				}
+			case "Battery":
+				status, _ := os.ReadFile(filepath.Join(dir, "status"))
+				discharging = discharging || strings.TrimSpace(string(status)) == "Discharging"
+			}
+		}
+		return discharging
+	case "darwin":
+		out, err := exec.Command("pmset", "-g", "batt").Output()
+		return err == nil && strings.Contains(string(out), "'Battery Power'")
+	}
+	return false
+}
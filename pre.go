@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+// runPre runs the --pre shell command, if any, as part of j, in the same
+// way as the command it precedes.  If it fails a warning is printed and,
+// with --pre-fatal, an error is returned so the command is not run.
+func runPre(j *job) error {
+	if flags.Pre == "" {
+		return nil
+	}
+	cmd, err := j.start(targetCommand(j, []string{"sh", "-c", flags.Pre}), false)
+	if err == nil {
+		err = j.wait(cmd)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case flags.PreFatal || j.wasKilled():
+		return fmt.Errorf("--pre: %v", err)
+	}
+	warnf("%s --pre failed: %v\n", now(), err)
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A problem is an error or warning found in the output of a command by a
+// problem matcher.
+type problem struct {
+	File     string
+	Line     int
+	Col      int    // 0 if not known
+	Severity string // e.g., error or warning, if known
+	Message  string
+}
+
+// String returns p in the form FILE:LINE:COL: MESSAGE, which is understood
+// by most editors.  COL is omitted if it is not known.
+func (p problem) String() string {
+	pos := fmt.Sprintf("%s:%d", p.File, p.Line)
+	if p.Col > 0 {
+		pos += fmt.Sprintf(":%d", p.Col)
+	}
+	if p.Severity != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", pos, p.Message)
+}
+
+// A matcher finds problems in the output of commands.  Its regular
+// expression is matched against each line of output, without any leading
+// white space, and must have named groups for the file and line.  The col,
+// severity, and message groups are optional.
+type matcher struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// builtinMatchers are the matchers that need not be defined in the config.
+var builtinMatchers = []*matcher{
+	mustMatcher("go", `^(?:vet: )?(?P<file>[^\s:]+\.go):(?P<line>\d+)(?::(?P<col>\d+))?: (?P<message>.*)$`),
+	mustMatcher("gcc", `^(?P<file>[^\s:]+):(?P<line>\d+):(?P<col>\d+): (?:fatal )?(?P<severity>error|warning): (?P<message>.*)$`),
+	mustMatcher("tsc", `^(?P<file>[^\s(]+)\((?P<line>\d+),(?P<col>\d+)\): (?P<severity>error|warning) (?P<message>.*)$`),
+}
+
+// configMatchers are the matchers defined by the config file.
+var configMatchers []*matcher
+
+// newMatcher returns the matcher called name that uses the regular
+// expression expr.
+func newMatcher(name, expr string) (*matcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string]bool{}
+	for _, g := range re.SubexpNames() {
+		groups[g] = true
+	}
+	if !groups["file"] || !groups["line"] {
+		return nil, fmt.Errorf("matcher %s: file and line groups are required", name)
+	}
+	return &matcher{name: name, re: re}, nil
+}
+
+func mustMatcher(name, expr string) *matcher {
+	m, err := newMatcher(name, expr)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// parseMatcher returns the matcher described by value, the value of a
+// matcher: line in the config, which is of the form NAME REGEXP.
+func parseMatcher(value string) (*matcher, error) {
+	name, expr, ok := strings.Cut(value, " ")
+	expr = strings.TrimSpace(expr)
+	if !ok || expr == "" {
+		return nil, fmt.Errorf("matcher: want NAME REGEXP")
+	}
+	return newMatcher(name, expr)
+}
+
+// allMatchers returns the matchers defined in the config followed by the
+// builtin matchers.  A matcher in the config hides a builtin matcher of the
+// same name.
+func allMatchers() []*matcher {
+	ms := append([]*matcher{}, configMatchers...)
+	for _, b := range builtinMatchers {
+		if lookupMatcher(configMatchers, b.name) == nil {
+			ms = append(ms, b)
+		}
+	}
+	return ms
+}
+
+// lookupMatcher returns the matcher in ms called name, or nil.
+func lookupMatcher(ms []*matcher, name string) *matcher {
+	for _, m := range ms {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// match returns the problem described by line, if it matches m.
+func (m *matcher) match(line string) (problem, bool) {
+	sm := m.re.FindStringSubmatch(line)
+	if sm == nil {
+		return problem{}, false
+	}
+	var p problem
+	for i, name := range m.re.SubexpNames() {
+		switch name {
+		case "file":
+			p.File = sm[i]
+		case "line":
+			p.Line, _ = strconv.Atoi(sm[i])
+		case "col":
+			p.Col, _ = strconv.Atoi(sm[i])
+		case "severity":
+			p.Severity = sm[i]
+		case "message":
+			p.Message = strings.TrimSpace(sm[i])
+		}
+	}
+	return p, p.File != "" && p.Line > 0
+}
+
+// findProblems returns the problems that ms find in out, in the order they
+// were first found.  A problem found more than once, e.g., by two
+// matchers or in two builds, is only returned once.
+func findProblems(out []byte, ms []*matcher) []problem {
+	var problems []problem
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		for _, m := range ms {
+			p, ok := m.match(line)
+			if !ok {
+				continue
+			}
+			if key := p.String(); !seen[key] {
+				seen[key] = true
+				problems = append(problems, p)
+			}
+			break
+		}
+	}
+	return problems
+}
+
+// summarizeProblems returns a summarizer that lists the problems ms find.
+func summarizeProblems(ms []*matcher) func(out []byte) []string {
+	return func(out []byte) []string {
+		problems := findProblems(out, ms)
+		if len(problems) == 0 {
+			return nil
+		}
+		var lines []string
+		for _, p := range problems {
+			lines = append(lines, p.String())
+		}
+		return append(lines, fmt.Sprintf("%d problems", len(problems)))
+	}
+}
+
+// writeQuickfix writes the problems found in out to path, one per line, in
+// the form FILE:LINE:COL: MESSAGE that vim's default errorformat and emacs's
+// compilation mode understand.  The problems are found by the matcher named
+// by --summarize, if any, otherwise by all the matchers.  path is replaced
+// even if there are no problems so it never lists problems that have been
+// fixed.
+func writeQuickfix(path string, out []byte) error {
+	ms := allMatchers()
+	if m := lookupMatcher(ms, flags.Summarize); m != nil {
+		ms = []*matcher{m}
+	}
+	var b strings.Builder
+	for _, p := range findProblems(out, ms) {
+		fmt.Fprintln(&b, p)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// A patternProfile records how long it took to expand a pattern, and how
+// many files it matched, during a pass (--profile).
+type patternProfile struct {
+	pattern string
+	elapsed time.Duration
+	files   int
+}
+
+// The profile of the current pass.
+var (
+	passPatterns []patternProfile
+	passStat     time.Duration // time spent stat'ing files
+	passStated   int           // number of files stat'ed
+)
+
+// profilePattern records that pattern took elapsed to expand and matched
+// files files.
+func profilePattern(pattern string, elapsed time.Duration, files int) {
+	if flags.Profile {
+		passPatterns = append(passPatterns, patternProfile{pattern, elapsed, files})
+	}
+}
+
+// profileStat records that stat'ing files files took elapsed.
+func profileStat(elapsed time.Duration, files int) {
+	if flags.Profile {
+		passStat += elapsed
+		passStated += files
+	}
+}
+
+// lastMem is the memory statistics at the time of the last report.
+var lastMem runtime.MemStats
+
+// reportProfile reports the profile of a pass that took elapsed to stderr
+// and starts a new profile.  The memory allocated since the last report is
+// included.
+func reportProfile(elapsed time.Duration) {
+	if !flags.Profile {
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(stderr, "%s pass %v: stat %d files %v, %d allocs %d bytes\n", now().Format("15:04:05.000"), elapsed.Round(time.Microsecond), passStated, passStat.Round(time.Microsecond), mem.Mallocs-lastMem.Mallocs, mem.TotalAlloc-lastMem.TotalAlloc)
+	lastMem = mem
+	for _, p := range passPatterns {
+		fmt.Fprintf(stderr, "\t%v\t%d files\t%s\n", p.elapsed.Round(time.Microsecond), p.files, p.pattern)
+	}
+	passPatterns = passPatterns[:0]
+	passStat, passStated = 0, 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A project is a directory supervised by --projects.
+type project struct {
+	name string
+	dir  string
+	args []string // additional arguments for the project's autocmd
+}
+
+// readProjects reads the --projects file at path.  Each line is a project
+// directory, relative to the directory of path, optionally followed by
+// additional arguments for the autocmd run in it.  Blank lines and lines
+// starting with # are ignored.
+func readProjects(path string) ([]*project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var projects []*project
+	names := map[string]bool{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		words, err := splitWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+		}
+		dir := expandPattern(words[0])
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+		p := &project{name: filepath.Base(dir), dir: dir, args: words[1:]}
+		for i := 2; names[p.name]; i++ {
+			p.name = fmt.Sprintf("%s-%d", filepath.Base(dir), i)
+		}
+		names[p.name] = true
+		projects = append(projects, p)
+	}
+	if len(projects) == 0 {
+		return nil, fmt.Errorf("%s: no projects", path)
+	}
+	return projects, nil
+}
+
+// projectArgs returns the flags in args to give the autocmd of each
+// project.  --projects is removed, as is --socket, which would have them all
+// share one control socket and lock.  The autocmds run in the project
+// directories, so the paths of pathFlags are made absolute, and each keeps
+// its own state.
+func projectArgs(args []string) ([]string, error) {
+	takesArg := flagsTakingArg()
+	paths := pathFlags()
+	var out []string
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		switch {
+		case arg == "--":
+			return append(append(out, arg), args...), nil
+		case !strings.HasPrefix(arg, "--"):
+			// Short flags do not name files.
+			out = append(out, arg)
+			for i, c := range arg[1:] {
+				if takesArg["-"+string(c)] {
+					if i == len(arg)-2 && len(args) > 0 {
+						out, args = append(out, args[0]), args[1:]
+					}
+					break
+				}
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok && takesArg[name] && len(args) > 0 {
+			value, args, ok = args[0], args[1:], true
+		}
+		switch name {
+		case "--projects", "--socket":
+		case "--state":
+			out = append(out, name+"="+stateDefault)
+		default:
+			if !ok {
+				out = append(out, arg)
+				break
+			}
+			if _, isPath := paths[name]; isPath {
+				abs, err := absPath(name, value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				value = abs
+			}
+			out = append(out, name+"="+value)
+		}
+	}
+	return out, nil
+}
+
+// superviseProjects runs an autocmd in each of the projects listed in the
+// --projects file, with args followed by the project's arguments, until
+// they all exit or autocmd is interrupted.  Each line they write is
+// prefixed with the name of its project.  An autocmd that fails is
+// restarted.
+func superviseProjects(path string, args []string) error {
+	projects, err := readProjects(path)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	width := 0
+	for _, p := range projects {
+		if len(p.name) > width {
+			width = len(p.name)
+		}
+	}
+	var (
+		mu       sync.Mutex
+		stopping bool
+		running  = map[*project]*exec.Cmd{}
+		wg       sync.WaitGroup
+	)
+	for i, p := range projects {
+		label := fmt.Sprintf("%-*s | ", width, p.name)
+		if colorOutput() {
+			label = "\033[" + prefixColors[i%len(prefixColors)] + "m" + label + "\033[0m"
+		}
+		prefix := func() string { return label }
+		wg.Add(1)
+		go func(p *project) {
+			defer wg.Done()
+			for {
+				cmd := exec.Command(exe, append(append([]string{}, args...), p.args...)...)
+				cmd.Dir = p.dir
+				stdout := &lineWriter{w: &prefixWriter{w: os.Stdout, prefix: prefix}}
+				stderr := &lineWriter{w: &prefixWriter{w: os.Stderr, prefix: prefix}}
+				cmd.Stdout, cmd.Stderr = stdout, stderr
+				mu.Lock()
+				if stopping {
+					mu.Unlock()
+					return
+				}
+				err := cmd.Start()
+				if err == nil {
+					running[p] = cmd
+				}
+				mu.Unlock()
+				if err == nil {
+					err = cmd.Wait()
+				}
+				stdout.flush()
+				stderr.flush()
+				mu.Lock()
+				delete(running, p)
+				done := stopping
+				mu.Unlock()
+				switch {
+				case done:
+					return
+				case err == nil:
+					printf("%s%s: autocmd exited\n", label, p.dir)
+					return
+				}
+				warnf("%s%s: autocmd failed: %v, restarting in 5s\n", label, p.dir, err)
+				time.Sleep(5 * time.Second)
+			}
+		}(p)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	select {
+	case <-finished:
+		return nil
+	case <-sigs:
+	}
+	// A signal sent while an autocmd is handling another, e.g., the
+	// interrupt from the terminal, may be lost, so keep sending them
+	// until they exit.
+	mu.Lock()
+	stopping = true
+	mu.Unlock()
+	sig := syscall.SIGTERM
+	for i := 0; ; i++ {
+		if i == 5 {
+			sig = syscall.SIGKILL
+		}
+		mu.Lock()
+		for _, cmd := range running {
+			cmd.Process.Signal(sig)
+		}
+		mu.Unlock()
+		select {
+		case <-finished:
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
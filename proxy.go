@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxyWait is how long the --proxy waits for the server to be ready before
+// failing a request.
+const proxyWait = 30 * time.Second
+
+// startProxy starts the reverse proxy described by spec, which is of the form
+// LISTEN->TARGET, e.g., ":3000->:8080".  Requests are forwarded to the server
+// at TARGET.  While the server is not accepting connections, e.g., because
+// it is being restarted, requests are held until it is.
+func startProxy(spec string) error {
+	laddr, target, ok := strings.Cut(spec, "->")
+	if !ok || laddr == "" || target == "" {
+		return fmt.Errorf("%s: must be LISTEN->TARGET", spec)
+	}
+	if strings.HasPrefix(target, ":") {
+		target = "localhost" + target
+	}
+	u, err := url.Parse("http://" + target)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return err
+	}
+	rp := httputil.NewSingleHostReverseProxy(u)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !waitReady(req, target) {
+			http.Error(w, "autocmd: server not ready", http.StatusServiceUnavailable)
+			return
+		}
+		rp.ServeHTTP(w, req)
+	})
+	go func() {
+		err := http.Serve(l, handler)
+		warnf("--proxy: %v\n", err)
+	}()
+	return nil
+}
+
+// waitReady waits until the server at target accepts connections and, if
+// specified, passes the --healthcheck.  It returns false if the server is
+// not ready within proxyWait or req is canceled.
+func waitReady(req *http.Request, target string) bool {
+	deadline := time.Now().Add(proxyWait)
+	for {
+		c, err := net.DialTimeout("tcp", target, time.Second)
+		if err == nil {
+			c.Close()
+			if flags.Healthcheck == "" || healthy() == nil {
+				return true
+			}
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-req.Context().Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
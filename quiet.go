@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quietHours are the hours, from the quiet-hours config directive, during
+// which commands are not run.  Sets whose names are in sets still run.
+type quietHours struct {
+	start, end int // minutes after midnight
+	sets       []string
+}
+
+// configQuiet are the quiet hours specified by the config, if any.
+var configQuiet *quietHours
+
+// inQuiet is true while commands are being held for quiet hours.
+var inQuiet bool
+
+// parseQuietHours parses the value of a quiet-hours directive, which is of
+// the form HH:MM-HH:MM [SET ...].
+func parseQuietHours(value string) (*quietHours, error) {
+	words := strings.Fields(value)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("quiet-hours: missing hours")
+	}
+	from, to, ok := strings.Cut(words[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet-hours: invalid hours: %q", words[0])
+	}
+	q := &quietHours{sets: words[1:]}
+	for _, x := range []struct {
+		s string
+		m *int
+	}{{from, &q.start}, {to, &q.end}} {
+		t, err := time.Parse("15:04", x.s)
+		if err != nil {
+			return nil, fmt.Errorf("quiet-hours: invalid time: %q", x.s)
+		}
+		*x.m = t.Hour()*60 + t.Minute()
+	}
+	return q, nil
+}
+
+// String returns q as the value of a quiet-hours directive.
+func (q *quietHours) String() string {
+	return strings.Join(append([]string{fmt.Sprintf("%02d:%02d-%02d:%02d", q.start/60, q.start%60, q.end/60, q.end%60)}, q.sets...), " ")
+}
+
+// contains returns true if t is within q.  The hours may span midnight.
+func (q *quietHours) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if q.start <= q.end {
+		return q.start <= m && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// exempt returns true if s runs during q.
+func (q *quietHours) exempt(s *set) bool {
+	for _, name := range q.sets {
+		if name == s.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// holdQuiet returns true if the sets in pending should not run yet because
+// it is quiet hours.  If any of them are exempt the first of those that
+// does not depend on another pending set (see after=) is moved to the front
+// of pending and it may run.  It must only be called from the main loop.
+func holdQuiet(pending []*set) bool {
+	q := configQuiet
+	if q == nil || !q.contains(now()) {
+		if inQuiet {
+			printf("%s Quiet hours are over, running delayed commands\n", now())
+			inQuiet = false
+		}
+		return false
+	}
+	if !inQuiet {
+		printf("%s Quiet hours until %02d:%02d, delaying commands\n", now(), q.end/60, q.end%60)
+		inQuiet = true
+	}
+	sets := allSets()
+	for i, s := range pending {
+		if q.exempt(s) && !waitsFor(sets, s, pending) {
+			copy(pending[1:i+1], pending[:i])
+			pending[0] = s
+			return false
+		}
+	}
+	return true
+}
+
+// waitsFor returns true if s depends on any of the other sets in pending.
+func waitsFor(sets []*set, s *set, pending []*set) bool {
+	for _, p := range pending {
+		if p != s && dependsOn(sets, s, p, map[*set]bool{}) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *quietHours
+	}{
+		{"22:00-07:00", &quietHours{start: 22 * 60, end: 7 * 60, sets: []string{}}},
+		{"09:30-17:15 lint docs", &quietHours{start: 9*60 + 30, end: 17*60 + 15, sets: []string{"lint", "docs"}}},
+		{"00:00-00:00", &quietHours{sets: []string{}}},
+		{"", nil},
+		{"22:00", nil},
+		{"22:00-", nil},
+		{"25:00-07:00", nil},
+		{"22:00-7pm", nil},
+	} {
+		q, err := parseQuietHours(tt.in)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("parseQuietHours(%q) = %+v, want an error", tt.in, q)
+		case tt.want != nil && err != nil:
+			t.Errorf("parseQuietHours(%q): %v", tt.in, err)
+		case tt.want != nil && !reflect.DeepEqual(q, tt.want):
+			t.Errorf("parseQuietHours(%q) = %+v, want %+v", tt.in, q, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		t, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	for _, tt := range []struct {
+		hours string
+		t     string
+		want  bool
+	}{
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "12:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"22:00-07:00", "21:59", false},
+		{"22:00-07:00", "22:00", true},
+		{"22:00-07:00", "23:59", true},
+		{"22:00-07:00", "00:00", true},
+		{"22:00-07:00", "06:59", true},
+		{"22:00-07:00", "07:00", false},
+		{"12:00-12:00", "12:00", false},
+	} {
+		q, err := parseQuietHours(tt.hours)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.contains(at(tt.t)); got != tt.want {
+			t.Errorf("%s contains %s = %v, want %v", tt.hours, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestHoldQuietAfter(t *testing.T) {
+	defer func(q *quietHours, in bool, cmd, config []*set) {
+		configQuiet, inQuiet, cmdSets, configSets = q, in, cmd, config
+	}(configQuiet, inQuiet, cmdSets, configSets)
+	hhmm := func(t time.Time) string { return t.Format("15:04") }
+	hours := hhmm(time.Now().Add(-time.Hour)) + "-" + hhmm(time.Now().Add(time.Hour))
+	a := &set{name: "a", after: []string{"b"}}
+	b := &set{name: "b"}
+	c := &set{name: "c"}
+	cmdSets, configSets = []*set{a, b, c}, nil
+	for _, tt := range []struct {
+		exempt  string
+		pending []*set
+		hold    bool
+		out     []*set
+	}{
+		{"a", []*set{b, a}, true, []*set{b, a}},
+		{"a", []*set{c, a}, false, []*set{a, c}},
+		{"a b", []*set{b, a}, false, []*set{b, a}},
+		{"a b", []*set{c, b, a}, false, []*set{b, c, a}},
+		{"c", []*set{b, a, c}, false, []*set{c, b, a}},
+	} {
+		q, err := parseQuietHours(hours + " " + tt.exempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configQuiet = q
+		pending := append([]*set{}, tt.pending...)
+		if hold := holdQuiet(pending); hold != tt.hold {
+			t.Errorf("exempt %s, pending %v: holdQuiet returned %v, want %v", tt.exempt, tt.pending, hold, tt.hold)
+		}
+		if !reflect.DeepEqual(pending, tt.out) {
+			t.Errorf("exempt %s, pending %v: got %v, want %v", tt.exempt, tt.pending, pending, tt.out)
+		}
+	}
+}
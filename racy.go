@@ -0,0 +1,57 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+)
+
+// A file system records modification times with a limited granularity, as
+// coarse as 2 seconds on FAT.  A file modified twice within the same
+// granule, without changing size, appears unchanged.  A file is racy if,
+// when it was stat'ed, its modification time was within --mtime-granularity
+// of the current time, or in the future due to clock skew.  The contents of
+// racy files are hashed so that such a change is still noticed.
+
+// isRacy returns true if path, described by fi, which was stat'ed at t, is
+// racy.
+func isRacy(path string, fi os.FileInfo, t time.Time) bool {
+	return flags.MtimeGranularity > 0 && !isRemote(path) && !fi.ModTime().Before(t.Add(-flags.MtimeGranularity))
+}
+
+// fileHash returns a hash of the contents of path.
+func fileHash(path string) (uint64, bool) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer fd.Close()
+	h := fnv.New64a()
+	if _, err := io.Copy(h, fd); err != nil {
+		return 0, false
+	}
+	return h.Sum64(), true
+}
+
+// racyChanged is called for each file, described by fi, that s found at t.
+// It returns true if the file was racy when last seen and its contents have
+// changed since then.  It records the hash of the file if it is now racy.
+func (s *set) racyChanged(path string, fi os.FileInfo, t time.Time) bool {
+	old, wasRacy := s.racy[path]
+	racy := isRacy(path, fi, t)
+	if !racy && !wasRacy {
+		return false
+	}
+	h, ok := fileHash(path)
+	switch {
+	case racy && ok:
+		if s.racy == nil {
+			s.racy = map[string]uint64{}
+		}
+		s.racy[path] = h
+	default:
+		delete(s.racy, path)
+	}
+	return wasRacy && ok && h != old
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactNames are the parts of the names of variables whose values are
+// secrets.  --redact adds to them.
+var redactNames = []string{"TOKEN", "SECRET", "PASSWORD"}
+
+// minSecret is the length of the shortest value redacted.  Shorter values,
+// e.g., PASSWORD_MIN=8, are more likely to appear by chance than to be
+// secrets.
+const minSecret = 4
+
+// redactRE matches the NAME=VALUE words whose NAME is secret.
+var redactRE = redactPattern()
+
+// redactPattern returns the regular expression for redactRE.
+func redactPattern() *regexp.Regexp {
+	var names []string
+	for _, n := range redactNames {
+		names = append(names, regexp.QuoteMeta(n))
+	}
+	return regexp.MustCompile(`(?i)([\w-]*(?:` + strings.Join(names, "|") + `)[\w-]*=)[^\s"']+`)
+}
+
+// setRedact adds the names given by --redact to redactNames.
+func setRedact() {
+	for _, n := range flags.Redact {
+		redactNames = append(redactNames, strings.ToUpper(n))
+	}
+	redactRE = redactPattern()
+}
+
+// secretName returns true if name is the name of a variable whose value is
+// a secret.
+func secretName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, n := range redactNames {
+		if strings.Contains(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// secrets returns the values of the secret variables in the environment of
+// autocmd and in the --env-file, longest first so that a secret containing
+// another is redacted whole.
+func secrets() []string {
+	var values []string
+	for _, env := range [][]string{os.Environ(), envFileVars()} {
+		for _, kv := range env {
+			k, v, _ := strings.Cut(kv, "=")
+			if len(v) >= minSecret && secretName(k) {
+				values = append(values, v)
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// redact returns s with the values of secret variables, and the values of
+// NAME=VALUE words with secret names, such as --api-token=VALUE, replaced
+// by ***.
+func redact(s string) string {
+	for _, v := range secrets() {
+		s = strings.ReplaceAll(s, v, "***")
+	}
+	return redactRE.ReplaceAllString(s, "${1}***")
+}
+
+// redacted returns a copy of r, with any secrets in its command and error
+// redacted, to be written to a file or sent elsewhere.
+func (r *result) redacted() *result {
+	c := *r
+	c.Command = make([]string, len(r.Command))
+	for i, arg := range r.Command {
+		c.Command[i] = redact(arg)
+	}
+	c.Error = redact(r.Error)
+	return &c
+}
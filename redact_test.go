@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	t.Setenv("AUTOCMD_TEST_TOKEN", "hunter22")
+	t.Setenv("AUTOCMD_TEST_PASSWORD", "abc") // too short to redact
+	t.Setenv("AUTOCMD_TEST_NAME", "visible")
+	defer setEnvFileVars(envFileVars())
+	setEnvFileVars([]string{"API_SECRET=s3cr3t-value"})
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"nothing to see", "nothing to see"},
+		{"curl -H hunter22 x", "curl -H *** x"},
+		{"abc visible", "abc visible"},
+		{"s3cr3t-value", "***"},
+		{"--api-token=xyz123 run", "--api-token=*** run"},
+		{"DB_PASSWORD=pw other=1", "DB_PASSWORD=*** other=1"},
+		{"my_secret_key='quoted'", "my_secret_key='quoted'"},
+		{"TOKEN=hunter22", "TOKEN=***"},
+	} {
+		if out := redact(tt.in); out != tt.out {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A remote watches a directory on another host (--remote).  An ssh helper
+// on the remote host periodically lists the files in the directory and
+// sends the listing back.  Patterns are matched against the most recent
+// listing rather than the local file system.
+type remote struct {
+	host  string
+	dir   string
+	local string // the local directory the remote directory appears as
+
+	mu    sync.Mutex
+	files map[string]os.FileInfo // the most recent listing
+	ready chan struct{}          // closed when the first listing arrives
+}
+
+// A fileInfo is an os.FileInfo that did not come from the local file system,
+// e.g., that of a remote file or of a file loaded from the --state file.
+type fileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return filepath.Base(fi.name) }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// newRemote returns a source that watches spec, which is of the form
+// [USER@]HOST:DIR, as if it were the local directory local.  It waits for
+// the first listing to arrive.
+func newRemote(local, spec string) (ChangeSource, error) {
+	host, dir, ok := strings.Cut(spec, ":")
+	if !ok || host == "" {
+		return nil, fmt.Errorf("%s: must be [USER@]HOST:DIR", spec)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	r := &remote{
+		host:  host,
+		dir:   dir,
+		local: local,
+		ready: make(chan struct{}),
+	}
+	go r.watch()
+	select {
+	case <-r.ready:
+	case <-time.After(time.Minute):
+		return nil, fmt.Errorf("%s: no response", spec)
+	}
+	return r, nil
+}
+
+// script returns the shell script run on the remote host.  The listing is
+// ended by a line containing a single period, which cannot otherwise
+// appear.  This requires GNU find on the remote host.
+func (r *remote) script() string {
+	secs := int((flags.Frequency + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return fmt.Sprintf(`cd %s && while :; do find . -mindepth 1 -printf '%%y %%s %%T@ %%P\n'; echo .; sleep %d; done`, shellQuote(r.dir), secs)
+}
+
+// watch runs the helper on the remote host, restarting it if it exits.
+func (r *remote) watch() {
+	for {
+		err := r.list()
+		warnf("remote %s: %v, restarting\n", r.host, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// list runs the helper once, recording each listing it sends.
+func (r *remote) list() error {
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", r.host, r.script())
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	files := map[string]os.FileInfo{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "." {
+			r.mu.Lock()
+			if r.files == nil {
+				close(r.ready)
+			}
+			r.files = files
+			r.mu.Unlock()
+			files = map[string]os.FileInfo{}
+			continue
+		}
+		if fi := parseListing(line); fi != nil {
+			files[filepath.Join(r.local, fi.name)] = fi
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return fmt.Errorf("helper exited")
+}
+
+// parseListing parses a line of the form "TYPE SIZE MTIME PATH", as produced
+// by find -printf '%y %s %T@ %P\n'.  It returns nil if line is malformed.
+func parseListing(line string) *fileInfo {
+	f := strings.SplitN(line, " ", 4)
+	if len(f) != 4 || f[3] == "" {
+		return nil
+	}
+	size, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	secs, err := strconv.ParseFloat(f[2], 64)
+	if err != nil {
+		return nil
+	}
+	fi := &fileInfo{
+		name:  f[3],
+		size:  size,
+		mtime: time.Unix(0, int64(secs*1e9)),
+	}
+	switch f[0] {
+	case "f":
+	case "d":
+		fi.mode = os.ModeDir
+	case "l":
+		fi.mode = os.ModeSymlink
+	default:
+		fi.mode = os.ModeIrregular
+	}
+	return fi
+}
+
+// glob adds the remote files that match any of patterns to f.
+func (r *remote) glob(patterns []string, f map[string]os.FileInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	globFiles(r.files, patterns, f)
+	return nil
+}
+
+// shellQuote quotes s for use as a single word by the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseListing(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want *fileInfo // nil if line is malformed
+	}{
+		{"f 12 1700000000.5 a/b.go", &fileInfo{name: "a/b.go", size: 12, mtime: time.Unix(1700000000, 5e8)}},
+		{"d 4096 1700000000 dir", &fileInfo{name: "dir", size: 4096, mode: os.ModeDir, mtime: time.Unix(1700000000, 0)}},
+		{"l 7 1700000000 link", &fileInfo{name: "link", size: 7, mode: os.ModeSymlink, mtime: time.Unix(1700000000, 0)}},
+		{"p 0 1700000000 fifo", &fileInfo{name: "fifo", mode: os.ModeIrregular, mtime: time.Unix(1700000000, 0)}},
+		{"f 1 1700000000 a file name", &fileInfo{name: "a file name", size: 1, mtime: time.Unix(1700000000, 0)}},
+		{"", nil},
+		{".", nil},
+		{"f 12 1700000000", nil},
+		{"f 12 1700000000 ", nil},
+		{"f x 1700000000 a.go", nil},
+		{"f 12 yesterday a.go", nil},
+	} {
+		got := parseListing(tt.line)
+		switch {
+		case got == nil && tt.want == nil:
+		case got == nil || tt.want == nil:
+			t.Errorf("parseListing(%q) = %+v, want %+v", tt.line, got, tt.want)
+		case got.name != tt.want.name || got.size != tt.want.size || got.mode != tt.want.mode:
+			t.Errorf("parseListing(%q) = %+v, want %+v", tt.line, got, tt.want)
+		case got.mtime.Sub(tt.want.mtime).Abs() > time.Microsecond:
+			t.Errorf("parseListing(%q) mtime = %v, want %v", tt.line, got.mtime, tt.want.mtime)
+		}
+	}
+}
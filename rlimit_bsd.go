@@ -0,0 +1,14 @@
+//go:build freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// rlimitAS is the resource limited by --mem-limit.
+const rlimitAS = syscall.RLIMIT_AS
+
+// setrlimit sets both the soft and hard limits of resource to value.  The
+// limits are signed on FreeBSD and DragonFly.
+func setrlimit(resource int, value uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: int64(value), Max: int64(value)})
+}
@@ -0,0 +1,12 @@
+package main
+
+import "syscall"
+
+// rlimitAS is the resource limited by --mem-limit.  OpenBSD cannot limit
+// virtual memory, so --mem-limit is not supported.
+const rlimitAS = -1
+
+// setrlimit sets both the soft and hard limits of resource to value.
+func setrlimit(resource int, value uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value})
+}
@@ -0,0 +1,13 @@
+//go:build !freebsd && !dragonfly && !openbsd
+
+package main
+
+import "syscall"
+
+// rlimitAS is the resource limited by --mem-limit.
+const rlimitAS = syscall.RLIMIT_AS
+
+// setrlimit sets both the soft and hard limits of resource to value.
+func setrlimit(resource int, value uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value})
+}
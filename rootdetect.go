@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rootMarkers are the files and directories whose presence marks the root
+// of a project for --root-detect.
+var rootMarkers = []string{".autocmd", "go.mod", ".git"}
+
+// findRoot returns the closest directory, starting with dir and working up,
+// that contains one of rootMarkers.
+func findRoot(dir string) (string, error) {
+	for {
+		for _, m := range rootMarkers {
+			if _, err := os.Stat(filepath.Join(dir, m)); err == nil {
+				return dir, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no project root (a directory containing %s) above %s", strings.Join(rootMarkers, ", "), dir)
+		}
+		dir = parent
+	}
+}
+
+// projectRoot is the directory --root-detect changed to, if it is not the
+// directory autocmd was started in.
+var projectRoot string
+
+// changeToRoot changes to the project root of the current directory
+// (--root-detect).
+func changeToRoot() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, err := findRoot(dir)
+	if err != nil {
+		return err
+	}
+	if root != dir {
+		projectRoot = root
+	}
+	return os.Chdir(root)
+}
+
+// pathFlags returns the flags whose values name files, by name, and the
+// values they set.  --root-detect and --projects both run autocmd in
+// another directory, so these paths are made absolute first.
+func pathFlags() map[string][]*string {
+	paths := map[string][]*string{
+		"--config":        {&flags.Config},
+		"--state":         {&flags.State},
+		"--history-file":  {&flags.HistoryFile},
+		"--log-file":      {&flags.LogFile},
+		"--env-file":      {&flags.EnvFile},
+		"--socket":        {&flags.Socket},
+		"--saves":         {&flags.Saves},
+		"--status-file":   {&flags.StatusFile},
+		"--quickfix":      {&flags.Quickfix},
+		"--coverage-file": {&flags.CoverageFile},
+		"--root":          nil,
+	}
+	for i := range flags.Root {
+		paths["--root"] = append(paths["--root"], &flags.Root[i])
+	}
+	return paths
+}
+
+// absPath returns path, given to the flag name, as an absolute path.  An
+// empty path, the @ default, and --saves=- are returned as is.
+func absPath(name, path string) (string, error) {
+	if path == "" || path == stateDefault || (name == "--saves" && path == "-") || filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Abs(path)
+}
+
+// absPaths makes the relative paths given to flags absolute so they still
+// name the same files once changeToRoot has changed directory.
+func absPaths() error {
+	for name, paths := range pathFlags() {
+		for _, p := range paths {
+			abs, err := absPath(name, *p)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			*p = abs
+		}
+	}
+	return nil
+}
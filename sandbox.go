@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// setSandbox adds bwrap(1) to runners so that commands run in new mount and
+// network namespaces (--sandbox).  The file system is read-only except for
+// the current directory and the writable paths, /tmp is a private empty
+// directory, and there is no network.
+func setSandbox(writable []string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("only supported on Linux")
+	}
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		return fmt.Errorf("bubblewrap is required: %v", err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"bwrap",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--unshare-net",
+		"--die-with-parent",
+	}
+	for _, path := range append([]string{dir}, writable...) {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		args = append(args, "--bind", path, path)
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	runners = append(runners, strings.Join(args, " "))
+	return nil
+}
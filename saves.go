@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveChan carries the paths of the files reported saved with --saves to
+// the main loop.
+var saveChan = make(chan string, 100)
+
+// startSaves starts reading the names of saved files from source, which is
+// either - for the standard input or the path of a unix socket to listen on.
+func startSaves(source string) error {
+	if source == "-" {
+		go readSaves(os.Stdin)
+		return nil
+	}
+	if err := removeStaleSocket(source); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", source)
+	if err != nil {
+		return err
+	}
+	os.Chmod(source, 0600)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				readSaves(c)
+			}()
+		}
+	}()
+	return nil
+}
+
+// removeStaleSocket removes path if it is a socket nobody is listening on.
+// It returns an error if path exists and is anything else, or if something
+// is listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeSocket == 0:
+		return fmt.Errorf("%s: exists and is not a socket", path)
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("%s: already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// readSaves reads the names of saved files, one per line, from r and sends
+// them to the main loop.
+func readSaves(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if path := strings.TrimSpace(scanner.Text()); path != "" {
+			saveChan <- path
+		}
+	}
+}
+
+// handleSaves handles the files reported saved since it was last called.
+// Each set watching a saved file is forced to run with the file as having
+// changed, or having been removed if it no longer exists.  It must only be
+// called from the main loop.
+func handleSaves() {
+	for {
+		var path string
+		select {
+		case path = <-saveChan:
+		default:
+			return
+		}
+		if filepath.IsAbs(path) {
+			if dir, err := os.Getwd(); err == nil {
+				if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+					path = rel
+				}
+			}
+		}
+		path = filepath.Clean(path)
+		if Excluded(path, excludes) || ignoredByFile(path, false) {
+			continue
+		}
+		c := byte('*')
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			c = '-'
+		}
+		for _, s := range allSets() {
+			if s.onCommit || !matchesAny(rootPatterns(s.patterns), path) {
+				continue
+			}
+			vprintf("%c %s%s\n", c, path, s.because(path))
+			s.noteChange(path, c)
+			s.forced = true
+		}
+	}
+}
+
+// matchesAny returns true if path matches any of patterns, as matched by
+// globFiles.
+func matchesAny(patterns []string, path string) bool {
+	pelems := strings.Split(path, "/")
+	for _, p := range patterns {
+		if matchElems(strings.Split(filepath.Clean(p), "/"), pelems) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// A binary is an executable we watch for changes.
+type binary struct {
+	path    string
+	fi      os.FileInfo // what we last acted on
+	pending os.FileInfo // a change we have seen once
+}
+
+// selfBinary is the autocmd binary when --watch-self is specified.
+// toolBinaries are the binaries specified by --watch-tool.
+var (
+	selfBinary   *binary
+	toolBinaries []*binary
+)
+
+// watchBinaries sets up watching the binaries requested by --watch-self
+// and --watch-tool.
+func watchBinaries() {
+	if flags.WatchSelf {
+		path, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--watch-self: %v\n", err)
+			os.Exit(1)
+		}
+		selfBinary = newBinary(path)
+	}
+	for _, tool := range flags.WatchTool {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--watch-tool: %v\n", err)
+			os.Exit(1)
+		}
+		toolBinaries = append(toolBinaries, newBinary(path))
+	}
+}
+
+func newBinary(path string) *binary {
+	fi, _ := os.Stat(path)
+	return &binary{path: path, fi: fi}
+}
+
+// changed returns true if b has changed and then not changed again for a
+// full check.  This prevents acting on a binary that is still being written.
+func (b *binary) changed() bool {
+	fi, err := os.Stat(b.path)
+	switch {
+	case err != nil, b.fi != nil && SameFile(fi, b.fi):
+		b.pending = nil
+		return false
+	case b.pending == nil || !SameFile(fi, b.pending):
+		b.pending = fi
+		return false
+	}
+	b.fi = fi
+	b.pending = nil
+	return true
+}
+
+// checkBinaries checks the watched binaries.  If a tool has changed then
+// all sets are forced to run.  If autocmd itself has changed then stop is
+// called, to stop any running command, and autocmd re-executes itself with
+// the same arguments.
+func checkBinaries(stop func()) {
+	for _, b := range toolBinaries {
+		if b.changed() {
+			printf("%s %s changed\n", now(), b.path)
+			for _, s := range allSets() {
+				s.forced = true
+			}
+		}
+	}
+	if selfBinary == nil || !selfBinary.changed() {
+		return
+	}
+	printf("%s %s changed, restarting\n", now(), selfBinary.path)
+	stop()
+	err := syscall.Exec(selfBinary.path, os.Args, os.Environ())
+	warnf("restart failed: %v\n", err)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// installService runs the install-service subcommand.  flagArgs are the
+// flags autocmd was given and args its arguments following install-service.
+// It writes a service that runs autocmd with flagArgs, less --dry-run, and
+// args in the current directory when the user logs in and restarts it if
+// it fails: a systemd user unit, or a launchd agent on macOS.  With
+// --dry-run the service is written to w rather than being installed.
+func installService(w io.Writer, flagArgs, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cmd := append([]string{exe}, withoutDryRun(flagArgs)...)
+	cmd = append(cmd, args...)
+	name := serviceName(dir)
+
+	var path, service string
+	var enable [][]string
+	switch runtime.GOOS {
+	case "linux":
+		path = filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "systemd", "user", name+".service")
+		service = systemdUnit(dir, cmd)
+		enable = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", name + ".service"},
+		}
+	case "darwin":
+		home, _ := os.UserHomeDir()
+		label := "com.github.pborman." + name
+		path = filepath.Join(home, "Library", "LaunchAgents", label+".plist")
+		service = launchdPlist(label, dir, cmd)
+		enable = [][]string{{"launchctl", "load", "-w", path}}
+	default:
+		return fmt.Errorf("services are not supported on %s", runtime.GOOS)
+	}
+	if flags.DryRun {
+		fmt.Fprintf(w, "# %s\n%s", path, service)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(service), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Wrote %s\n", path)
+	for _, args := range enable {
+		c := exec.Command(args[0], args[1:]...)
+		c.Stdout, c.Stderr = w, os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+// withoutDryRun returns the flags in args without --dry-run and -n,
+// including an n in a group of short flags such as -vn.
+func withoutDryRun(args []string) []string {
+	takesArg := flagsTakingArg()
+	var out []string
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		switch {
+		case arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-"):
+			return append(append(out, arg), args...)
+		case strings.HasPrefix(arg, "--"):
+			if name, _, _ := strings.Cut(arg, "="); name == "--dry-run" {
+				continue
+			}
+			out = append(out, arg)
+			if takesArg[arg] && len(args) > 0 {
+				out, args = append(out, args[0]), args[1:]
+			}
+		default:
+			group, value := "-", []string(nil)
+			for i, c := range arg[1:] {
+				if takesArg["-"+string(c)] {
+					// The rest of the group is the argument.
+					group += arg[1+i:]
+					if i == len(arg)-2 && len(args) > 0 {
+						value, args = args[:1], args[1:]
+					}
+					break
+				}
+				if c != 'n' {
+					group += string(c)
+				}
+			}
+			if group != "-" {
+				out = append(out, group)
+			}
+			out = append(out, value...)
+		}
+	}
+	return out
+}
+
+// serviceName returns the name of the service for autocmd in dir.  As with
+// stateDir, it includes a hash of dir so that two projects of the same name
+// do not share a service.
+func serviceName(dir string) string {
+	name := []byte(filepath.Base(dir))
+	for i, c := range name {
+		if !isIdentChar(c) && c != '-' && c != '.' {
+			name[i] = '-'
+		}
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return fmt.Sprintf("autocmd-%s-%x", name, sum[:4])
+}
+
+// systemdUnit returns a systemd user unit that runs cmd in dir.
+func systemdUnit(dir string, cmd []string) string {
+	var words []string
+	for _, arg := range cmd {
+		words = append(words, systemdQuote(arg))
+	}
+	// % starts a specifier in both Description and WorkingDirectory.
+	escaped := strings.ReplaceAll(dir, "%", "%%")
+	return fmt.Sprintf(`[Unit]
+Description=autocmd in %s
+
+[Service]
+WorkingDirectory=%s
+Environment=%s
+ExecStart=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`, escaped, escaped, systemdQuote("PATH="+os.Getenv("PATH")), strings.Join(words, " "))
+}
+
+// systemdQuote quotes s as a single word of a systemd unit.
+func systemdQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `%`, `%%`, `$`, `$$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// launchdPlist returns a launchd agent called label that runs cmd in dir.
+func launchdPlist(label, dir string, cmd []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+`, xmlEscape(label), xmlEscape(dir))
+	for _, arg := range cmd {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	fmt.Fprintf(&b, `	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>PATH</key>
+		<string>%s</string>
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+</dict>
+</plist>
+`, xmlEscape(os.Getenv("PATH")))
+	return b.String()
+}
+
+// xmlEscape escapes the characters that are special in XML text and
+// attribute values.
+var xmlEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace
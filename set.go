@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// A set is a set of sources to watch, usually the files matching its
+// patterns, and the command to run when any of them change.
+type set struct {
+	name     string   // optional name of the set
+	after    []string // names of sets that must run before this set
+	command  []string
+	patterns []string
+	sources  []setSource // what causes the set to run, see setsource.go
+	seen     map[string]os.FileInfo
+	spare    map[string]os.FileInfo // reused by same to hold the next seen
+	changes  map[string]byte        // files changed since the set last ran, see noteChange
+	reasons  []string               // other reasons to run since the set last ran, see noteReason
+	forced   bool                   // run even if nothing changed
+	onCommit bool                   // run only when a git commit is made
+	produces []string               // patterns of files the command produces
+	freq     time.Duration          // how often to check, if not --frequency
+	gate     string                 // shell command that must succeed for changes to run the set
+	checked  time.Time              // when the set was last checked
+	tracked  int                    // files watched at the last check, see checkFileCounts
+	starts   []time.Time            // recent starts, see noteStart
+	looping  bool                   // paused because it keeps triggering itself
+	runStart time.Time              // when the set last started running
+	runEnd   time.Time              // when the set last completed, see same
+	alive    *keepAlive             // the command started with --keep-alive
+	racy     map[string]uint64      // hashes of racy files, see racy.go
+	hashes   map[string]uint64      // hashes of files with --network-fs
+}
+
+// parseSet returns the set described by args, which are of the form
+// [OPTION ...] PATTERN [...] -- CMD [...].  See parseOptions for the options.
+func parseSet(args []string) (*set, error) {
+	var s set
+	s.seen = map[string]os.FileInfo{}
+	args, err := s.parseOptions(args)
+	if err != nil {
+		return nil, err
+	}
+	for x, arg := range args {
+		if arg == "--" {
+			s.command = args[x+1:]
+			s.patterns = expandPatterns(args[:x])
+			break
+		}
+	}
+	if err := s.addSources(); err != nil {
+		return nil, err
+	}
+	if len(s.command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	return &s, nil
+}
+
+// newGoSet returns the set for --go, which watches the go patterns and runs
+// the command in args, which may start with set options.
+func newGoSet(args []string) (*set, error) {
+	s := &set{
+		patterns: gopatterns,
+		seen:     map[string]os.FileInfo{},
+	}
+	command, err := s.parseOptions(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	s.command = command
+	if err := s.addSources(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// addSources adds the sources that are not set options to the sources of s:
+// its patterns, which come first, and the --url flags.
+func (s *set) addSources() error {
+	switch {
+	case s.onCommit && len(s.patterns) > 0:
+		return fmt.Errorf("patterns are not permitted with on=commit")
+	case len(s.patterns) > 0:
+		s.sources = append([]setSource{globSource{}}, s.sources...)
+	case len(s.sources) == 0:
+		return fmt.Errorf("no patterns specified")
+	}
+	for _, url := range flags.URL {
+		s.sources = append(s.sources, &urlSource{url: url, flag: true})
+	}
+	return nil
+}
+
+func newSet(args []string) *set {
+	s, err := parseSet(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	return s
+}
+
+// parseOptions parses any leading set options from args and returns the
+// remaining arguments.  Set options are of the form KEY=VALUE:
+//
+//	name=NAME	the name of the set
+//	after=NAME,...	sets that must complete before this set runs
+//	on=commit	run when a git commit is made rather than on changes
+//	produces=PATTERN,...	files the command produces
+//	frequency=DUR	how often to check for changes
+//	every=DUR	how often to run even if nothing changed
+//	url=URL,...	URLs to watch
+//	if=CMD	shell command that must succeed for changes to run the set
+//
+// Words that are not of this form end the options.
+func (s *set) parseOptions(args []string) ([]string, error) {
+	for len(args) > 0 {
+		key, value, ok := strings.Cut(args[0], "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "name":
+			if value == "" || strings.ContainsAny(value, " \t") {
+				return nil, fmt.Errorf("invalid set name: %q", value)
+			}
+			s.name = value
+		case "after":
+			s.after = append(s.after, strings.Split(value, ",")...)
+		case "produces":
+			s.produces = append(s.produces, strings.Split(value, ",")...)
+		case "frequency":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid frequency: %q", value)
+			}
+			s.freq = d
+		case "if":
+			if value == "" {
+				return nil, fmt.Errorf("empty if option")
+			}
+			s.gate = value
+		case "url":
+			for _, url := range strings.Split(value, ",") {
+				if err := checkURL(url); err != nil {
+					return nil, fmt.Errorf("invalid url option: %v", err)
+				}
+				s.sources = append(s.sources, &urlSource{url: url})
+			}
+		case "every":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid every: %q", value)
+			}
+			s.sources = append(s.sources, &timerSource{every: d})
+		case "on":
+			if value != "commit" {
+				return nil, fmt.Errorf("invalid on option: %q", value)
+			}
+			s.onCommit = true
+			s.sources = append(s.sources, &commitSource{})
+		default:
+			return args, nil
+		}
+		args = args[1:]
+	}
+	return args, nil
+}
+
+// frequency returns how often s is checked for changes.
+func (s *set) frequency() time.Duration {
+	if s.freq > 0 {
+		return s.freq
+	}
+	return flags.Frequency
+}
+
+// due returns true if s should be checked for changes at time t, which is
+// the time of a tick of the check ticker, whose interval is interval.  Ticks
+// are not exactly interval apart, so s is due if it will not be checked
+// again before it is more overdue than it is early now.
+func (s *set) due(t time.Time, interval time.Duration) bool {
+	if t.Sub(s.checked) < s.frequency()-interval/2 {
+		return false
+	}
+	s.checked = t
+	return true
+}
+
+// gateTimeout is how long an if= command may run.  It is run before the
+// set runs, holding up autocmd.
+const gateTimeout = 10 * time.Second
+
+// open returns true if s has no if= option or its command succeeds.  The
+// command fails if it runs for more than gateTimeout.
+func (s *set) open() bool {
+	if s.gate == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gateTimeout)
+	defer cancel()
+	cmd := shellCmd(ctx, s.gate)
+	if verbosity > 0 {
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+	}
+	if err := cmd.Run(); err != nil {
+		printf("%s Skipping set %s, if=%s: %v\n", now(), s, s.gate, err)
+		return false
+	}
+	return true
+}
+
+// checkInterval returns how often to check for changes, which is the
+// shortest frequency of any set.
+func checkInterval() time.Duration {
+	d := flags.Frequency
+	for _, s := range allSets() {
+		if s.freq > 0 && s.freq < d {
+			d = s.freq
+		}
+	}
+	return d
+}
+
+// key returns a string that identifies the name, sources, and command of s.
+// The every= option is not part of it, see mergeSets.
+func (s *set) key() string {
+	var sources []string
+	for _, src := range s.sources {
+		if _, ok := src.(*timerSource); !ok {
+			sources = append(sources, src.option())
+		}
+	}
+	return fmt.Sprintf("%q %q %q %q %q %q", s.name, s.after, s.produces, sources, s.patterns, s.command)
+}
+
+// String returns the name of s, or its number if it is not named.
+func (s *set) String() string {
+	if s.name != "" {
+		return s.name
+	}
+	for i, s2 := range allSets() {
+		if s2 == s {
+			return fmt.Sprint(i + 1)
+		}
+	}
+	return "?"
+}
+
+// label returns the name of s followed by ": " for use in messages.  It
+// returns "" if s has no name.
+func (s *set) label() string {
+	if s.name == "" {
+		return ""
+	}
+	return s.name + ": "
+}
+
+// lookupSet returns the set in sets with the provided name or number.  It
+// returns nil if there is no such set.
+func lookupSet(sets []*set, name string) *set {
+	for _, s := range sets {
+		if s.name == name {
+			return s
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 && n <= len(sets) {
+		return sets[n-1]
+	}
+	return nil
+}
+
+// dependencies returns the sets in sets that s must run after.  Sets that
+// do not exist are ignored.
+func (s *set) dependencies(sets []*set) []*set {
+	var deps []*set
+	for _, name := range s.after {
+		if d := lookupSet(sets, name); d != nil && d != s {
+			deps = append(deps, d)
+		}
+	}
+	return deps
+}
+
+// orderSets returns sets sorted so each set follows the sets it depends on.
+// Otherwise the order of sets is preserved.  Dependencies on sets not in
+// sets are ignored, as are dependency cycles.
+func orderSets(sets []*set) []*set {
+	state := map[*set]int{} // 0: in sets, 1: visiting, 2: done
+	for _, s := range sets {
+		state[s] = 0
+	}
+	all := allSets()
+	ordered := make([]*set, 0, len(sets))
+	var visit func(s *set)
+	visit = func(s *set) {
+		if st, ok := state[s]; !ok || st != 0 {
+			return
+		}
+		state[s] = 1
+		for _, d := range s.dependencies(all) {
+			visit(d)
+		}
+		state[s] = 2
+		ordered = append(ordered, s)
+	}
+	for _, s := range sets {
+		visit(s)
+	}
+	return ordered
+}
+
+// dependents returns the sets that depend, directly or indirectly, on s, in
+// dependency order.
+func dependents(s *set) []*set {
+	all := allSets()
+	deps := map[*set]bool{s: true}
+	var sets []*set
+	for _, s2 := range orderSets(all) {
+		for _, d := range s2.dependencies(all) {
+			if deps[d] {
+				deps[s2] = true
+				sets = append(sets, s2)
+				break
+			}
+		}
+	}
+	return sets
+}
+
+// changedDependents returns the sets that depend on s and have changed,
+// skipping any sets in skip.
+func changedDependents(s *set, skip []*set) []*set {
+	var sets []*set
+Dependents:
+	for _, d := range dependents(s) {
+		for _, s2 := range skip {
+			if d == s2 {
+				continue Dependents
+			}
+		}
+		changed := d.changed(now())
+		if !d.forced && changed && !d.open() {
+			d.reasons = nil
+			continue
+		}
+		if changed || d.forced {
+			d.forced = false
+			sets = append(sets, d)
+		}
+	}
+	return sets
+}
+
+// validateSets returns an error if two sets have the same name, a set is
+// named with a number, or a set depends on a set that does not exist or on
+// itself.
+func validateSets(sets []*set) error {
+	names := map[string]bool{}
+	for _, s := range sets {
+		if s.name == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(s.name); err == nil {
+			return fmt.Errorf("set name %q is a number", s.name)
+		}
+		if names[s.name] {
+			return fmt.Errorf("duplicate set name %q", s.name)
+		}
+		names[s.name] = true
+	}
+	for _, s := range sets {
+		for _, step := range steps(s.command) {
+			if len(step) == 0 {
+				return fmt.Errorf("set %s: empty command in pipeline", s)
+			}
+		}
+		for _, name := range s.after {
+			if lookupSet(sets, name) == nil {
+				return fmt.Errorf("set %s: no such set: %s", s, name)
+			}
+		}
+		if dependsOn(sets, s, s, map[*set]bool{}) {
+			return fmt.Errorf("set %s depends on itself", s)
+		}
+	}
+	return nil
+}
+
+// dependsOn returns true if s directly or indirectly depends on target.
+func dependsOn(sets []*set, s, target *set, seen map[*set]bool) bool {
+	if seen[s] {
+		return false
+	}
+	seen[s] = true
+	for _, d := range s.dependencies(sets) {
+		if d == target || dependsOn(sets, d, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// cmdSets are the sets specified on the command line.  configSets are the
+// sets specified by the config file, they change when the config is reread.
+var cmdSets, configSets []*set
+
+// allSets returns all the sets we are currently watching.
+func allSets() []*set {
+	return append(append([]*set{}, cmdSets...), configSets...)
+}
+
+// excludes are the patterns of files that are never watched.
+var excludes []string
+
+// While a command runs, and until the first check after it completes, files
+// it changes are ignored (--ignore-own-output and --output).  outputStart is
+// when the command started and outputEnd is when it completed, or zero if it
+// is still running.  outputStart is zero when no command has run since the
+// last check.
+var outputStart, outputEnd time.Time
+
+// ownOutput returns true if path, which is described by fi, or nil if it
+// has been removed, appears to have been changed by the command.  With
+// --ignore-own-output, any file modified while the command ran is considered
+// an output.  With --output, only the files matching the output patterns are.
+// Removing a file does not change its modification time so only matching the
+// output patterns can identify a removed file as an output.
+func ownOutput(path string, fi os.FileInfo) bool {
+	if outputStart.IsZero() || !flags.IgnoreOwnOutput && len(flags.Output) == 0 {
+		return false
+	}
+	if len(flags.Output) > 0 && !Excluded(path, flags.Output) {
+		return false
+	}
+	if fi == nil {
+		return len(flags.Output) > 0
+	}
+	return modifiedDuring(fi, outputStart, outputEnd)
+}
+
+// modifiedDuring returns true if fi was modified between start and end.  An
+// end of zero means now.
+func modifiedDuring(fi os.FileInfo, start, end time.Time) bool {
+	// File systems record modification times with less precision
+	// than the clock, so the start time is rounded down.
+	mt := fi.ModTime()
+	return !mt.Before(start.Truncate(time.Second)) && (end.IsZero() || !mt.After(end))
+}
+
+// produced returns true if path, which is described by fi, or nil if it has
+// been removed, was produced by the command of s during its last run, as
+// declared by the produces option.  A set does not trigger itself by
+// producing files, other sets watching the files still run.
+func (s *set) produced(path string, fi os.FileInfo) bool {
+	if s.runStart.IsZero() || !Excluded(path, s.produces) {
+		return false
+	}
+	return fi == nil || modifiedDuring(fi, s.runStart, s.runEnd)
+}
+
+func (s *set) same() bool {
+	if flags.Saves != "" {
+		// Changes are only noted by handleSaves.
+		return true
+	}
+	// Collect all files currently matching our pattern
+	files, err := multiGlob(s.patterns, s.spare)
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+	for path, fi := range files {
+		if Excluded(path, excludes) || ignoredByFile(path, fi.IsDir()) {
+			delete(files, path)
+		}
+	}
+	// Compare them with what we have seen before.
+	// Anything left in Seen has been deleted.
+	// Anything not in Seen is new.
+	same := true
+	t := now()
+	for path, f1 := range files {
+		// Skip directories and files we do not watch
+		if !Tracked(f1) {
+			delete(files, path)
+			continue
+		}
+		f2, ok := s.seen[path]
+		delete(s.seen, path)
+		if !ok {
+			s.firstSeen(path)
+		}
+		racyChanged := s.racyChanged(path, f1, t)
+		if ok && f1.ModTime().Before(f2.ModTime()) {
+			// E.g., restored from a backup or an older commit.
+			vprintf("%s: modification time went backwards\n", path)
+		}
+		if !ok || !SameFile(f1, f2) && !s.sameContents(path, f1, f2) || racyChanged {
+			if !inGitDiff(path) || ownOutput(path, f1) || s.produced(path, f1) {
+				if verbosity >= 2 {
+					vprintf("= %s\n", path)
+				}
+				continue
+			}
+			same = false
+			if ok {
+				s.noteChange(path, '*')
+				vprintf("* %s%s\n", path, s.because(path))
+			} else {
+				s.noteChange(path, '+')
+				vprintf("+ %s%s\n", path, s.because(path))
+			}
+		} else if verbosity >= 2 {
+			// Avoid the allocation of calling vprintf for
+			// every unchanged file on every pass.
+			vprintf("= %s\n", path)
+		}
+	}
+	if len(s.seen) != 0 {
+		for path, f2 := range s.seen {
+			if unstatable(path) {
+				// Assume it is still there.
+				files[path] = f2
+				continue
+			}
+			delete(s.racy, path)
+			delete(s.hashes, path)
+			if inGitDiff(path) && !ownOutput(path, nil) && !s.produced(path, nil) {
+				s.noteChange(path, '-')
+				vprintf("- %s%s\n", path, s.because(path))
+				same = false
+			}
+		}
+	}
+	s.spare = s.seen
+	s.seen = files
+	if !s.runEnd.IsZero() {
+		// Everything the last run produced has now been seen.
+		s.runStart, s.runEnd = time.Time{}, time.Time{}
+	}
+	return same
+}
+
+// because returns, for messages, which set and which of its patterns
+// caused path to be watched, e.g., " (set build, pattern .../*.go)".
+func (s *set) because(path string) string {
+	pelems := strings.Split(filepath.Clean(path), "/")
+	for _, p := range s.patterns {
+		for _, rp := range rootPatterns([]string{p}) {
+			if matchElems(strings.Split(filepath.Clean(rp), "/"), pelems) {
+				return fmt.Sprintf(" (set %s, pattern %s)", s, p)
+			}
+		}
+	}
+	return fmt.Sprintf(" (set %s)", s)
+}
+
+// noteChange records that path has been added (+), changed (*), or removed
+// (-) since s last ran.
+func (s *set) noteChange(path string, c byte) {
+	if s.changes == nil {
+		s.changes = map[string]byte{}
+	}
+	// A file that is new since the last run is still new if it
+	// has changed again.
+	if c == '*' && s.changes[path] == '+' {
+		return
+	}
+	s.changes[path] = c
+}
+
+// changedFiles returns the files that have changed since s last ran, sorted
+// by name.  Each name is preceded by "+ " if it was added, "* " if it
+// changed, and "- " if it was removed.
+func (s *set) changedFiles() []string {
+	files := make([]string, 0, len(s.changes))
+	for path, c := range s.changes {
+		files = append(files, string(c)+" "+path)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i][2:] < files[j][2:]
+	})
+	return files
+}
+
+// presentFiles returns the files that were added or changed, but not
+// removed, since s last ran, sorted by name.
+func (s *set) presentFiles() []string {
+	var files []string
+	for _, f := range s.changedFiles() {
+		if f[0] != '-' {
+			files = append(files, f[2:])
+		}
+	}
+	return files
+}
+
+// expand returns s.command, run for files, with the following placeholders
+// expanded:
+//
+//	{file}	the first file added or changed
+//	{files}	all the files added or changed
+//	{dir}	the directory of {file}
+//	{base}	the name of {file} without its directory or extension
+//	{ext}	the extension of {file}, including the .
+//	{time}	the current time in RFC 3339 format
+//
+// A word that is just {files} is replaced by one word for each file,
+// otherwise {files} is replaced by the files separated by spaces.
+// Anything else in braces is left as is.
+func (s *set) expand(files []string) []string {
+	var file string
+	if len(files) > 0 {
+		file = files[0]
+	}
+	base := filepath.Base(file)
+	ext := filepath.Ext(file)
+	r := strings.NewReplacer(
+		"{file}", file,
+		"{files}", strings.Join(files, " "),
+		"{dir}", filepath.Dir(file),
+		"{base}", strings.TrimSuffix(base, ext),
+		"{ext}", ext,
+		"{time}", now().Format(time.RFC3339),
+	)
+	var command []string
+	for _, word := range s.command {
+		if word == "{files}" {
+			command = append(command, files...)
+			continue
+		}
+		command = append(command, r.Replace(word))
+	}
+	return command
+}
+
+// expandFile returns the command of s for use with --per-file on file.
+// The placeholders refer to just file.  If the command does not refer to
+// the file then file is appended to each command of the pipeline.
+func (s *set) expandFile(file string) []string {
+	command := s.expand([]string{file})
+	for _, word := range s.command {
+		for _, p := range []string{"{file", "{dir}", "{base}", "{ext}"} {
+			if strings.Contains(word, p) {
+				return command
+			}
+		}
+	}
+	var pipeline []string
+	for i, step := range steps(command) {
+		if i > 0 {
+			pipeline = append(pipeline, "++")
+		}
+		pipeline = append(append(pipeline, step...), file)
+	}
+	return pipeline
+}
+
+// steps splits command into the commands of its pipeline, which are
+// separated by "++".
+func steps(command []string) [][]string {
+	var steps [][]string
+	for {
+		x := 0
+		for x < len(command) && command[x] != "++" {
+			x++
+		}
+		steps = append(steps, command[:x])
+		if x == len(command) {
+			return steps
+		}
+		command = command[x+1:]
+	}
+}
+
+// runSteps runs the commands of the pipeline command as part of j, one at a
+// time, stopping at the first command that fails.  If serve is set the last
+// command is given the --listen socket.
+func runSteps(j *job, command []string, serve bool) error {
+	all := steps(command)
+	for i, step := range all {
+		cmd, err := j.start(targetCommand(j, step), serve && i == len(all)-1)
+		if err == nil {
+			err = j.wait(cmd)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *set) run() (*job, chan struct{}) {
+	clear()
+
+	// At this point we assume the spawned processes have
+	// completed.  We forget about them.
+
+	s.runStart, s.runEnd = now(), time.Time{}
+	files := s.presentFiles()
+	if flags.GitDiff != "" {
+		files = s.gitDiffFiles()
+	}
+	changed := s.changedFiles()
+	s.changes = nil
+	for _, why := range s.reasons {
+		printf("%s Running set %s, %s\n", now(), s, why)
+	}
+	s.reasons = nil
+	command := s.expand(files)
+	r := &result{
+		Set:     s.String(),
+		Command: command,
+		Files:   changed,
+		Start:   now(),
+	}
+
+	finished := make(chan struct{})
+	if flags.DryRun {
+		if flags.PerFile {
+			for _, file := range files {
+				printf("%s Would start %s%s\n", now(), s.label(), s.expandFile(file))
+			}
+		} else {
+			printf("%s Would start %s%s\n", now(), s.label(), command)
+		}
+		for _, path := range changed {
+			printf("\t%s%s\n", path, s.because(path[2:]))
+		}
+		close(finished)
+		return nil, finished
+	}
+
+	if flags.KeepAlive {
+		s.runKeepAlive(command, files, r)
+		close(finished)
+		return nil, finished
+	}
+
+	if flags.PerFile {
+		return s.runPerFile(files, r)
+	}
+
+	printf("%s Starting %s%s\n", now(), s.label(), command)
+	started(r)
+
+	j := newJob()
+	j.stdout, j.stderr = s.output()
+	c := captureOutput(j)
+	if flags.Healthcheck != "" {
+		go healthCheck(j, *r, finished)
+	}
+	go func() {
+		defer recoverRun(s, r, j, finished)
+		err := syncChanges(j, changed)
+		if err == nil {
+			err = runPre(j)
+		}
+		if err == nil {
+			err = runSteps(j, command, true)
+			vprintf("command returns %v\n", err)
+		}
+		if !j.wasKilled() {
+			c.report()
+		}
+		if err != nil {
+			printf("Command died with %v\n", err)
+		} else {
+			printf("Command exited\n")
+		}
+		completed(r, j, err)
+		close(finished)
+	}()
+	return j, finished
+}
+
+// runPerFile runs the command of s once for each of files, running up to
+// --jobs commands at once.  r describes the run.
+func (s *set) runPerFile(files []string, r *result) (*job, chan struct{}) {
+	printf("%s Starting %s%s for %d files\n", now(), s.label(), s.command, len(files))
+	started(r)
+	j := newJob()
+	j.stdout, j.stderr = s.output()
+	c := captureOutput(j)
+	finished := make(chan struct{})
+	go func() {
+		defer recoverRun(s, r, j, finished)
+		err := syncChanges(j, r.Files)
+		if err == nil {
+			err = runPre(j)
+		}
+		if err != nil {
+			warnf("%v\n", err)
+			completed(r, j, err)
+			close(finished)
+			return
+		}
+		jobs := flags.Jobs
+		if jobs < 1 {
+			jobs = 1
+		}
+		sem := make(chan struct{}, jobs)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		failed := 0
+		for _, file := range files {
+			sem <- struct{}{}
+			if j.wasKilled() {
+				break
+			}
+			wg.Add(1)
+			go func(file string) {
+				defer wg.Done()
+				if err := s.runFile(j, file); err != nil && err != errKilled {
+					printf("%s: command died with %v\n", file, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				<-sem
+			}(file)
+		}
+		wg.Wait()
+		if !j.wasKilled() {
+			c.report()
+		}
+		if failed > 0 {
+			err = fmt.Errorf("commands failed for %d of %d files", failed, len(files))
+			printf("Commands failed for %d of %d files\n", failed, len(files))
+		} else {
+			printf("Commands exited\n")
+		}
+		completed(r, j, err)
+		close(finished)
+	}()
+	return j, finished
+}
+
+// runFile runs the command of s for file as part of the job j.  A panic is
+// reported and returned as an error so the other files are still run.
+func (s *set) runFile(j *job, file string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			reportPanic("running the command for "+file, v)
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return runSteps(j, s.expandFile(file), true)
+}
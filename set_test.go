@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderSets(t *testing.T) {
+	defer func(cmd, config []*set) { cmdSets, configSets = cmd, config }(cmdSets, configSets)
+	byName := map[string]*set{}
+	newSets := func(specs ...string) {
+		cmdSets, configSets = nil, nil
+		byName = map[string]*set{}
+		for _, spec := range specs {
+			name, after, _ := strings.Cut(spec, ":")
+			s := &set{name: name}
+			if after != "" {
+				s.after = strings.Split(after, ",")
+			}
+			byName[name] = s
+			cmdSets = append(cmdSets, s)
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		sets []string // NAME:AFTER,...
+		in   string
+		out  string
+	}{
+		{
+			name: "none",
+			sets: []string{"a", "b"},
+		},
+		{
+			name: "independent",
+			sets: []string{"a", "b", "c"},
+			in:   "c a b",
+			out:  "c a b",
+		},
+		{
+			name: "dependency first",
+			sets: []string{"a:b", "b"},
+			in:   "a b",
+			out:  "b a",
+		},
+		{
+			name: "chain",
+			sets: []string{"a:b", "b:c", "c"},
+			in:   "a b c",
+			out:  "c b a",
+		},
+		{
+			name: "dependency not in sets",
+			sets: []string{"a:b", "b", "c"},
+			in:   "c a",
+			out:  "c a",
+		},
+		{
+			name: "unknown dependency",
+			sets: []string{"a:x", "b"},
+			in:   "a b",
+			out:  "a b",
+		},
+		{
+			name: "cycle",
+			sets: []string{"a:b", "b:a"},
+			in:   "a b",
+			out:  "b a",
+		},
+		{
+			name: "self",
+			sets: []string{"a:a", "b"},
+			in:   "b a",
+			out:  "b a",
+		},
+	} {
+		newSets(tt.sets...)
+		var in []*set
+		for _, name := range strings.Fields(tt.in) {
+			in = append(in, byName[name])
+		}
+		var out []string
+		for _, s := range orderSets(in) {
+			out = append(out, s.name)
+		}
+		if got := strings.Join(out, " "); got != tt.out {
+			t.Errorf("%s: orderSets(%s) = %q, want %q", tt.name, tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestGoSetChanged(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func(patterns []string) { gopatterns = patterns }(gopatterns)
+	gopatterns = []string{"*.go"}
+	if err := os.WriteFile("a.go", []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newGoSet([]string{"go", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.changed(time.Now()) {
+		t.Errorf("--go set did not see a.go")
+	}
+	if s.changed(time.Now()) {
+		t.Errorf("--go set changed without a change")
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes("a.go", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !s.changed(time.Now()) {
+		t.Errorf("--go set did not see a.go change")
+	}
+}
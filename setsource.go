@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A setSource is an input of a set whose changes cause the set to run: the
+// files matching its patterns, found with a ChangeSource, git commits, a
+// URL, or a timer.  Events that are not tied to a set, such as files
+// reported by --saves, a --trigger, --after, --after-pid, or a changed
+// --watch-tool, force sets to run instead.
+type setSource interface {
+	// changed returns true if the source has changed since it was last
+	// called.  t is the time of the check.  Why is noted with noteReason
+	// rather than reported, as s might not run.
+	changed(s *set, t time.Time) bool
+
+	// option returns the set option that adds the source, or "" if it
+	// was not added by an option, e.g., patterns.
+	option() string
+}
+
+// reset checks the sources of s as of t and forgets any changes, e.g., so
+// that a set whose files changed while autocmd was not running does not
+// run.
+func (s *set) reset(t time.Time) {
+	s.changed(t)
+	s.changes = nil
+	s.reasons = nil
+}
+
+// noteReason notes why, a reason for s to run other than its files
+// changing.  The reasons are reported when s starts.
+func (s *set) noteReason(why string) {
+	s.reasons = append(s.reasons, why)
+}
+
+// changed returns true if any of the sources of s have changed as of t.
+// Each source is checked, even once one has changed, so that they all
+// start afresh when s runs.
+func (s *set) changed(t time.Time) bool {
+	changed := false
+	for _, src := range s.sources {
+		if src.changed(s, t) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// timer returns the every= source of s, or nil.
+func (s *set) timer() *timerSource {
+	for _, src := range s.sources {
+		if ts, ok := src.(*timerSource); ok {
+			return ts
+		}
+	}
+	return nil
+}
+
+// A globSource is the files matching the patterns of a set.
+type globSource struct{}
+
+func (globSource) changed(s *set, _ time.Time) bool { return !s.same() }
+func (globSource) option() string                   { return "" }
+
+// A commitSource is the commits made to the git repository, see on=commit.
+type commitSource struct {
+	commits int // commitCount when last checked
+}
+
+func (c *commitSource) changed(*set, time.Time) bool {
+	if c.commits == commitCount {
+		return false
+	}
+	c.commits = commitCount
+	return true
+}
+
+func (c *commitSource) option() string { return "on=commit" }
+
+// A urlSource is a URL watched by url= or --url.
+type urlSource struct {
+	url      string
+	flag     bool // from --url rather than url=
+	seen     int  // the version of url last seen
+	watching bool // seen is set
+}
+
+func (u *urlSource) changed(s *set, _ time.Time) bool {
+	v := urlVersion(u.url)
+	changed := u.watching && v != u.seen
+	if changed {
+		s.noteReason(u.url + " changed")
+	}
+	u.seen, u.watching = v, true
+	return changed
+}
+
+func (u *urlSource) option() string {
+	if u.flag {
+		return ""
+	}
+	return "url=" + u.url
+}
+
+// A timerSource runs a set every so often, see every=.  It changes when
+// neither it has changed nor the set has started running for every.  The
+// first period starts when the set is first checked.
+type timerSource struct {
+	every time.Duration
+	last  time.Time // when the current period started
+}
+
+func (ts *timerSource) changed(s *set, t time.Time) bool {
+	if ts.last.IsZero() {
+		ts.last = t
+		return false
+	}
+	if t.Sub(ts.last) < ts.every {
+		return false
+	}
+	ts.last = t
+	s.noteReason(fmt.Sprintf("every %v", ts.every))
+	return true
+}
+
+func (ts *timerSource) option() string { return fmt.Sprintf("every=%v", ts.every) }
+
+// setTimer replaces the every= source of s with ts, which may be nil.  ts
+// carries on with the current period of the source it replaces.
+func (s *set) setTimer(ts *timerSource) {
+	if old := s.timer(); old != nil && ts != nil {
+		ts.last = old.last
+	}
+	sources := s.sources[:0]
+	for _, src := range s.sources {
+		if _, ok := src.(*timerSource); !ok {
+			sources = append(sources, src)
+		}
+	}
+	if ts != nil {
+		sources = append(sources, ts)
+	}
+	s.sources = sources
+}
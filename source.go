@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A ChangeSource finds the files that match patterns.  Changes are detected
+// by comparing what a source finds from one pass to the next, so a source
+// need only report the files that currently exist and what they look like.
+type ChangeSource interface {
+	// glob adds the files that match any of patterns to f.
+	glob(patterns []string, f map[string]os.FileInfo) error
+}
+
+// sourceTypes are the kinds of ChangeSource that can be named by --source.
+// Each function returns a source for the files in the directory dir.  arg is
+// the argument given to the source, if any.  A new kind of source only needs
+// to be added here.
+var sourceTypes = map[string]func(dir, arg string) (ChangeSource, error){
+	"poll":     newPollSource,
+	"remote":   newRemote,
+	"watchman": newWatchman,
+	"git":      newGitSource,
+}
+
+// A mount is a ChangeSource used for the patterns within dir.
+type mount struct {
+	dir string
+	src ChangeSource
+}
+
+// mounts are the sources added with --source, longest directory first.
+// Patterns not within any of their directories are polled.
+var mounts []mount
+
+// poll is the default ChangeSource, which reads the local file system.
+var poll ChangeSource = pollSource{}
+
+// addSource adds the source described by spec, which is of the form
+// [DIR=]NAME[:ARG].  DIR defaults to the current directory.
+func addSource(spec string) error {
+	dir, name := ".", spec
+	if x := strings.Index(spec, "="); x >= 0 {
+		dir, name = spec[:x], spec[x+1:]
+	}
+	name, arg, _ := strings.Cut(name, ":")
+	newSource, ok := sourceTypes[name]
+	if !ok {
+		var names []string
+		for name := range sourceTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%s: unknown source (want one of %s)", spec, strings.Join(names, ", "))
+	}
+	dir = filepath.Clean(dir)
+	for _, m := range mounts {
+		if m.dir == dir {
+			return fmt.Errorf("%s: %s already has a source", spec, dir)
+		}
+	}
+	src, err := newSource(dir, arg)
+	if err != nil {
+		return fmt.Errorf("%s: %v", spec, err)
+	}
+	mounts = append(mounts, mount{dir: dir, src: src})
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].dir) > len(mounts[j].dir)
+	})
+	return nil
+}
+
+// literalPrefix returns the directories at the start of pattern that
+// precede any shell metacharacters or "...", or all of pattern if it has
+// neither.  It is "" if pattern starts with either.
+func literalPrefix(pattern string) string {
+	var lead []string
+	for _, e := range strings.Split(filepath.Clean(pattern), "/") {
+		if e == "..." || hasMeta(e) {
+			break
+		}
+		lead = append(lead, e)
+	}
+	return strings.Join(lead, "/")
+}
+
+// owner returns the source of the file or directory path, which is the
+// source of the longest directory containing it.  The config files are
+// always local.
+func owner(path string) ChangeSource {
+	if len(mounts) == 0 || isConfigFile(path) {
+		return poll
+	}
+	for _, m := range mounts {
+		if m.dir == "." && !filepath.IsAbs(path) || path == m.dir || strings.HasPrefix(path, m.dir+"/") {
+			return m.src
+		}
+	}
+	return poll
+}
+
+// sourceFor returns the source of pattern, which is the owner of the
+// directories at the start of pattern.  Files matching pattern within the
+// directory of another source are found by that source, see sourceGlob.
+func sourceFor(pattern string) ChangeSource {
+	return owner(literalPrefix(pattern))
+}
+
+// isRemote returns true if path is not on the local file system, i.e., it
+// was found by a remote source.
+func isRemote(path string) bool {
+	_, ok := owner(path).(*remote)
+	return ok
+}
+
+// foreign returns true if the directory path, below root, belongs to a
+// different source than root, so that walking root need not descend into
+// it.
+func foreign(root, path string) bool {
+	return len(mounts) > 0 && owner(path) != owner(root)
+}
+
+// nestedMounts returns the mounts whose directories are below the
+// directories at the start of pattern, which might contain files matching
+// pattern.
+func nestedMounts(pattern string) []mount {
+	prefix := literalPrefix(pattern)
+	if prefix == filepath.Clean(pattern) {
+		return nil
+	}
+	var nested []mount
+	for _, m := range mounts {
+		switch {
+		case m.dir == "." || m.dir == prefix:
+		case prefix == "" && !filepath.IsAbs(m.dir), strings.HasPrefix(m.dir, prefix+"/"):
+			nested = append(nested, m)
+		}
+	}
+	return nested
+}
+
+// within returns patterns that match the files matching pattern that are
+// within dir, a directory below the directories at the start of pattern.
+// The patterns may match some files that are not within dir as well.  It
+// returns nil if no file within dir can match pattern.
+func within(pattern, dir string) []string {
+	prefix := literalPrefix(pattern)
+	rest := strings.Split(strings.TrimPrefix(filepath.Clean(pattern)[len(prefix):], "/"), "/")
+	dirs := strings.Split(strings.TrimPrefix(dir[len(prefix):], "/"), "/")
+	base := prefix
+	for i, e := range rest {
+		if e == "..." {
+			// ... may end above dir, leaving the rest of the
+			// pattern to reach into dir, or at or below dir.
+			tail := strings.Join(rest[i+1:], "/")
+			var patterns []string
+			for _, d := range dirs {
+				if tail != "" {
+					patterns = append(patterns, filepath.Join(base, tail))
+				}
+				base = filepath.Join(base, d)
+			}
+			return append(patterns, filepath.Join(dir, "...", tail))
+		}
+		if len(dirs) == 0 {
+			return []string{filepath.Join(dir, strings.Join(rest[i:], "/"))}
+		}
+		if ok, _ := filepath.Match(e, dirs[0]); !ok {
+			return nil
+		}
+		base = filepath.Join(base, dirs[0])
+		dirs = dirs[1:]
+	}
+	return nil
+}
+
+// sourceGlob adds the files matching patterns, which have already been
+// adjusted by rootPatterns, to f.  Each file is found by its owner: each
+// pattern is given to its source and, restricted to their directories, to
+// the sources of any directories below the start of the pattern.
+func sourceGlob(patterns []string, f map[string]os.FileInfo) error {
+	if len(mounts) == 0 {
+		return poll.glob(patterns, f)
+	}
+	var srcs []ChangeSource
+	bySource := map[ChangeSource][]string{}
+	add := func(src ChangeSource, patterns ...string) {
+		if len(patterns) == 0 {
+			return
+		}
+		if bySource[src] == nil {
+			srcs = append(srcs, src)
+		}
+		bySource[src] = append(bySource[src], patterns...)
+	}
+	for _, p := range patterns {
+		add(sourceFor(p), p)
+		for _, m := range nestedMounts(p) {
+			add(m.src, within(p, m.dir)...)
+		}
+	}
+	for _, src := range srcs {
+		found := map[string]os.FileInfo{}
+		if err := src.glob(bySource[src], found); err != nil {
+			return err
+		}
+		for path, fi := range found {
+			if owner(path) == src {
+				f[path] = fi
+			}
+		}
+	}
+	return nil
+}
+
+// A pollSource finds files by reading directories and stat'ing files on
+// each pass.  This is how autocmd normally finds files.
+type pollSource struct{}
+
+func newPollSource(dir, arg string) (ChangeSource, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("poll takes no argument")
+	}
+	return pollSource{}, nil
+}
+
+func (pollSource) glob(patterns []string, f map[string]os.FileInfo) error {
+	mp := matchPool.Get().(*[]string)
+	matches := (*mp)[:0]
+	defer func() {
+		*mp = matches[:0]
+		matchPool.Put(mp)
+	}()
+	for _, p := range patterns {
+		start := time.Now()
+		n := len(matches)
+		expanded := Expand(p)
+		for _, p := range expanded {
+			m, err := glob(p)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, m...)
+		}
+		profilePattern(p, time.Since(start), len(matches)-n)
+		if verbosity >= 3 {
+			vprintf("%s: %d patterns after expanding ..., %d matches, %v\n", p, len(expanded), len(matches)-n, time.Since(start))
+		}
+	}
+	start := time.Now()
+	statAll(matches, f)
+	profileStat(time.Since(start), len(matches))
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// A savedState is the contents of the --state file.
+type savedState struct {
+	Dir     string                          `json:"dir"`               // the directory autocmd ran in
+	Sets    map[string]map[string]savedFile `json:"sets"`              // seen files by set key
+	Journal uint64                          `json:"journal,omitempty"` // position in the change journal
+	Volume  string                          `json:"volume,omitempty"`  // the change journal
+}
+
+// A savedFile is what was seen of a file.
+type savedFile struct {
+	Size  int64       `json:"size"`
+	Mtime time.Time   `json:"mtime"`
+	Mode  os.FileMode `json:"mode"`
+}
+
+// saveState writes what each set has seen to the --state file, if any.  The
+// file is replaced atomically so a crash never leaves a partial file.
+func saveState() {
+	if flags.State == "" {
+		return
+	}
+	dir, _ := os.Getwd()
+	state := savedState{Dir: dir, Sets: map[string]map[string]savedFile{}, Journal: journalPos, Volume: journalVol}
+	for _, s := range allSets() {
+		files := map[string]savedFile{}
+		for path, fi := range s.seen {
+			files[path] = savedFile{Size: fi.Size(), Mtime: fi.ModTime(), Mode: fi.Mode()}
+		}
+		state.Sets[s.key()] = files
+	}
+	data, err := json.Marshal(&state)
+	if err != nil {
+		warnf("state: %v\n", err)
+		return
+	}
+	tmp := flags.State + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		warnf("state: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, flags.State); err != nil {
+		warnf("state: %v\n", err)
+	}
+}
+
+// loadState sets what each set has seen from the --state file and returns
+// the sets that were found in it.  A missing file is not an error, nor is a
+// file written by autocmd running in a different directory, which is
+// ignored.  Files the change journal, if any, reports as changed since the
+// state was saved are forgotten, so they are seen as added even if they look
+// the same as before.
+func loadState() ([]*set, error) {
+	data, err := os.ReadFile(flags.State)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", flags.State, err)
+	}
+	if dir, _ := os.Getwd(); state.Dir != dir {
+		vprintf("%s: ignoring state saved in %s\n", flags.State, state.Dir)
+		return nil, nil
+	}
+	changed := journalChanged(state.Journal, state.Volume)
+	var loaded []*set
+	for _, s := range allSets() {
+		files, ok := state.Sets[s.key()]
+		if !ok {
+			continue
+		}
+		s.seen = map[string]os.FileInfo{}
+		for path, f := range files {
+			if changed == nil || !changed[realPath(path)] {
+				s.seen[path] = &fileInfo{name: path, size: f.Size, mode: f.Mode, mtime: f.Mtime}
+			}
+		}
+		loaded = append(loaded, s)
+	}
+	return loaded, nil
+}
+
+// journalChanged returns the paths, with symbolic links resolved, under the
+// current directory that the change journal vol reports as changed since
+// the position pos.  It returns nil if they are not known.
+func journalChanged(pos uint64, vol string) map[string]bool {
+	if pos == 0 || vol == "" {
+		return nil
+	}
+	if v, _ := journalVolume("."); v != vol {
+		return nil
+	}
+	paths, ok := journalChanges(realPath("."), pos)
+	if !ok {
+		return nil
+	}
+	vprintf("%s: %d changes since the state was saved\n", flags.State, len(paths))
+	changed := map[string]bool{}
+	for _, p := range paths {
+		changed[p] = true
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spinnerFrames are the frames of the spinner shown while a command runs.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// While a command runs a status line with a spinner and how long it has
+// been running is shown at the bottom of the terminal.  Output is written a
+// line at a time while holding outputMu, which erases the status line
+// first, so the status line never corrupts the output.  It is redrawn on
+// the next tick.
+var (
+	spinnerMu    sync.Mutex
+	spinning     *result // the run the status line is for, or nil
+	statusShown  bool    // the status line is on the screen, see outputMu
+	spinnerStart sync.Once
+)
+
+// spinnerEnabled returns true if the status line is shown, which requires
+// autocmd to be writing its own, line buffered, output directly to a
+// terminal.
+func spinnerEnabled() bool {
+	switch {
+	case flags.NoSpinner, flags.Quiet, flags.Passthrough, flags.TUI, flags.KeepAlive:
+		return false
+	case flags.LogFile != "", flags.LogFormat != "" && flags.LogFormat != "console":
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// spinRun starts showing the status line for r.
+func spinRun(r *result) {
+	if !spinnerEnabled() {
+		return
+	}
+	spinnerStart.Do(func() { go spinner() })
+	spinnerMu.Lock()
+	spinning = r
+	spinnerMu.Unlock()
+}
+
+// stopSpin stops showing the status line for r.
+func stopSpin(r *result) {
+	spinnerMu.Lock()
+	if spinning == r {
+		spinning = nil
+	}
+	spinnerMu.Unlock()
+	outputMu.Lock()
+	eraseStatus()
+	outputMu.Unlock()
+}
+
+// spinner draws the status line ten times a second while a command is
+// running.
+func spinner() {
+	for n := 0; ; n++ {
+		time.Sleep(100 * time.Millisecond)
+		outputMu.Lock()
+		spinnerMu.Lock()
+		r := spinning
+		spinnerMu.Unlock()
+		if r != nil {
+			_, cols := termSize(os.Stdout)
+			line := truncate(statusText(r, spinnerFrames[n%len(spinnerFrames)]), cols-1)
+			os.Stdout.WriteString("\r" + line + "\033[K")
+			statusShown = true
+		}
+		outputMu.Unlock()
+	}
+}
+
+// statusText returns the status line for r, which is running, preceded by
+// frame.  It includes how long runs of the set typically take, if known.
+func statusText(r *result, frame string) string {
+	elapsed := now().Sub(r.Start).Round(100 * time.Millisecond)
+	text := fmt.Sprintf("%s running %s %v", frame, strings.Join(r.Command, " "), elapsed)
+	if eta := etaText(r.Set); eta != "" {
+		text += " (" + eta + ")"
+	}
+	return text
+}
+
+// eraseStatus erases the status line if it is shown.  outputMu must be
+// held.
+func eraseStatus() {
+	if statusShown {
+		os.Stdout.WriteString("\r\033[K")
+		statusShown = false
+	}
+}
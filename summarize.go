@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// summarizer returns the summarizer for --summarize=kind, or nil if there is
+// no such kind.  A summarizer returns the lines of the summary of out, the
+// output of a run, or nil if there is nothing to report.  kind is go-test,
+// the name of a problem matcher, or problems, which uses all the matchers.
+func summarizer(kind string) func(out []byte) []string {
+	switch kind {
+	case "go-test":
+		return summarizeGoTest
+	case "problems":
+		return summarizeProblems(allMatchers())
+	}
+	if m := lookupMatcher(allMatchers(), kind); m != nil {
+		return summarizeProblems([]*matcher{m})
+	}
+	return nil
+}
+
+// maxCapture is the most output of a run that is kept for --summarize.  If
+// a run writes more, only the last maxCapture bytes are kept.
+const maxCapture = 4 << 20
+
+// A capture holds the output of a run.
+type capture struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, p...)
+	if len(c.buf) > maxCapture {
+		c.buf = append(c.buf[:0], c.buf[len(c.buf)-maxCapture:]...)
+	}
+	return len(p), nil
+}
+
+// captureOutput arranges for the output of j to also be captured if there
+// is a --summarize, --quickfix, or --coverage-file.  It returns nil if
+// there is not.
+func captureOutput(j *job) *capture {
+	if flags.Summarize == "" && flags.Quickfix == "" && flags.CoverageFile == "" {
+		return nil
+	}
+	c := &capture{}
+	j.stdout = io.MultiWriter(j.stdout, c)
+	j.stderr = io.MultiWriter(j.stderr, c)
+	return c
+}
+
+// report reports on the output captured by c once the run has completed, as
+// requested by --summarize, --quickfix, and --coverage-file.  It does nothing
+// if c is nil.
+func (c *capture) report() {
+	if c == nil {
+		return
+	}
+	if flags.Quickfix != "" {
+		c.mu.Lock()
+		err := writeQuickfix(flags.Quickfix, c.buf)
+		c.mu.Unlock()
+		if err != nil {
+			warnf("quickfix: %v\n", err)
+		}
+	}
+	if flags.CoverageFile != "" {
+		c.mu.Lock()
+		err := writeCoverage(flags.CoverageFile, c.buf)
+		c.mu.Unlock()
+		if err != nil {
+			warnf("coverage-file: %v\n", err)
+		}
+	}
+	if flags.Summarize != "" {
+		c.summarize()
+	}
+}
+
+// summarize prints the summary of the output captured by c, with the lines
+// that begin with FAIL in red on a terminal.
+func (c *capture) summarize() {
+	summarize := summarizer(flags.Summarize)
+	if summarize == nil {
+		// The matcher was removed from the config.
+		return
+	}
+	c.mu.Lock()
+	lines := summarize(c.buf)
+	c.mu.Unlock()
+	if colorOutput() && (flags.LogFormat == "" || flags.LogFormat == "console") && flags.LogFile == "" {
+		for i, line := range lines {
+			if strings.HasPrefix(line, "FAIL") {
+				lines[i] = "\033[31m" + line + "\033[0m"
+			}
+		}
+	}
+	if len(lines) > 0 {
+		printf("%s\n", strings.Join(lines, "\n"))
+	}
+}
+
+// maxBuildErrors is the most build errors reported for a package.
+const maxBuildErrors = 5
+
+// A goTestFailure is a package that failed.
+type goTestFailure struct {
+	pkg    string
+	status string   // e.g., "[build failed]"
+	tests  []string // the failed tests
+	errors []string // build errors
+}
+
+// A goTestEvent is an event written by go test -json.
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// parseGoTestEvent returns the event line describes if it was written by go
+// test -json.
+func parseGoTestEvent(line string) (goTestEvent, bool) {
+	var ev goTestEvent
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+		return ev, false
+	}
+	return ev, true
+}
+
+// summarizeGoTest summarizes the output of go test, with or without -json.
+// Each failed package is listed followed by its failed tests, each with the
+// first line it logged, or the first few errors that stopped it building.
+func summarizeGoTest(out []byte) []string {
+	var (
+		failures []*goTestFailure
+		byPkg    = map[string]*goTestFailure{}
+		failed   []string              // failed tests not yet assigned a package
+		firstMsg = map[string]string{} // the first line each test logged
+		test     string                // the test whose output this is
+		build    string                // the package whose build errors these are
+	)
+	failure := func(pkg string) *goTestFailure {
+		f := byPkg[pkg]
+		if f == nil {
+			f = &goTestFailure{pkg: pkg}
+			byPkg[pkg] = f
+			failures = append(failures, f)
+		}
+		return f
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if ev, ok := parseGoTestEvent(line); ok {
+			switch ev.Action {
+			case "output", "build-output":
+				line = strings.TrimSuffix(ev.Output, "\n")
+			case "fail":
+				if ev.Test == "" && ev.Package != "" {
+					failure(ev.Package)
+				}
+				continue
+			default:
+				continue
+			}
+		} else if strings.HasPrefix(line, "{") {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "# "):
+			build, test = "", ""
+			if f := strings.Fields(line); len(f) > 1 {
+				build = f[1]
+			}
+		case strings.HasPrefix(trimmed, "=== "):
+			if f := strings.Fields(trimmed); len(f) > 2 {
+				test = f[2]
+			}
+		case strings.HasPrefix(trimmed, "--- FAIL: "):
+			test = strings.Fields(trimmed)[2]
+			failed = append(failed, test)
+		case strings.HasPrefix(trimmed, "--- "):
+			test = ""
+		case strings.HasPrefix(line, "FAIL\t"), strings.HasPrefix(line, "FAIL "):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			f := failure(fields[1])
+			if x := strings.Index(line, "["); x >= 0 {
+				f.status = line[x:]
+			}
+			f.tests = append(f.tests, leafTests(failed)...)
+			failed, test, build = nil, "", ""
+		case strings.HasPrefix(line, "ok "), strings.HasPrefix(line, "ok\t"):
+			failed, test, build = nil, "", ""
+		case build != "" && !strings.HasPrefix(line, " ") && trimmed != "":
+			if f := failure(build); len(f.errors) < maxBuildErrors {
+				f.errors = append(f.errors, trimmed)
+			}
+		case trimmed == "FAIL", trimmed == "PASS", strings.HasPrefix(line, "exit status "):
+			test = ""
+		case test != "" && strings.HasPrefix(line, " ") && trimmed != "" && firstMsg[test] == "":
+			firstMsg[test] = trimmed
+		case strings.HasPrefix(line, "panic: ") && len(failed) > 0:
+			if t := failed[len(failed)-1]; firstMsg[t] == "" {
+				firstMsg[t] = line
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].pkg < failures[j].pkg
+	})
+	var lines []string
+	ntests := 0
+	for _, f := range failures {
+		lines = append(lines, strings.TrimSpace("FAIL "+f.pkg+" "+f.status))
+		for _, t := range f.tests {
+			lines = append(lines, strings.TrimSuffix(fmt.Sprintf("\t%s: %s", t, firstMsg[t]), ": "))
+		}
+		for _, e := range f.errors {
+			lines = append(lines, "\t"+e)
+		}
+		ntests += len(f.tests)
+	}
+	return append(lines, fmt.Sprintf("%d tests failed in %d packages", ntests, len(failures)))
+}
+
+// leafTests returns tests without the tests that only failed because one of
+// their subtests did.
+func leafTests(tests []string) []string {
+	var leaves []string
+Tests:
+	for _, t := range tests {
+		for _, t2 := range tests {
+			if strings.HasPrefix(t2, t+"/") {
+				continue Tests
+			}
+		}
+		leaves = append(leaves, t)
+	}
+	return leaves
+}
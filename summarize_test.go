@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeGoTest(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		out  []string // lines of go test output
+		want []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "pass",
+			out: []string{
+				"ok  \texample.com/a\t0.01s",
+				"ok  \texample.com/b\t(cached)",
+			},
+		},
+		{
+			name: "failed test",
+			out: []string{
+				"--- FAIL: TestFoo (0.00s)",
+				"    foo_test.go:10: got 1, want 2",
+				"    foo_test.go:11: another",
+				"FAIL",
+				"FAIL\texample.com/a\t0.01s",
+				"ok  \texample.com/b\t0.01s",
+			},
+			want: []string{
+				"FAIL example.com/a",
+				"\tTestFoo: foo_test.go:10: got 1, want 2",
+				"1 tests failed in 1 packages",
+			},
+		},
+		{
+			name: "subtests",
+			out: []string{
+				"=== RUN   TestFoo",
+				"=== RUN   TestFoo/bar",
+				"    foo_test.go:20: bad bar",
+				"--- FAIL: TestFoo (0.00s)",
+				"    --- FAIL: TestFoo/bar (0.00s)",
+				"FAIL",
+				"FAIL\texample.com/a\t0.01s",
+			},
+			want: []string{
+				"FAIL example.com/a",
+				"\tTestFoo/bar: foo_test.go:20: bad bar",
+				"1 tests failed in 1 packages",
+			},
+		},
+		{
+			name: "build failed",
+			out: []string{
+				"# example.com/a",
+				"./a.go:3:2: undefined: x",
+				"./a.go:4:2: undefined: y",
+				"FAIL\texample.com/a [build failed]",
+			},
+			want: []string{
+				"FAIL example.com/a [build failed]",
+				"\t./a.go:3:2: undefined: x",
+				"\t./a.go:4:2: undefined: y",
+				"0 tests failed in 1 packages",
+			},
+		},
+		{
+			name: "bare build header",
+			out: []string{
+				"# ",
+				"#",
+				"--- FAIL: ",
+				"FAIL",
+				"FAIL ",
+			},
+		},
+		{
+			name: "panic",
+			out: []string{
+				"--- FAIL: TestFoo (0.00s)",
+				"panic: runtime error [recovered]",
+				"FAIL\texample.com/a\t0.01s",
+			},
+			want: []string{
+				"FAIL example.com/a",
+				"\tTestFoo: panic: runtime error [recovered]",
+				"1 tests failed in 1 packages",
+			},
+		},
+		{
+			name: "json",
+			out: []string{
+				`{"Action":"run","Package":"example.com/a","Test":"TestFoo"}`,
+				`{"Action":"output","Package":"example.com/a","Test":"TestFoo","Output":"=== RUN   TestFoo\n"}`,
+				`{"Action":"output","Package":"example.com/a","Test":"TestFoo","Output":"    foo_test.go:10: oops\n"}`,
+				`{"Action":"output","Package":"example.com/a","Test":"TestFoo","Output":"--- FAIL: TestFoo (0.00s)\n"}`,
+				`{"Action":"fail","Package":"example.com/a","Test":"TestFoo"}`,
+				`{"Action":"output","Package":"example.com/a","Output":"FAIL\texample.com/a\t0.01s\n"}`,
+				`{"Action":"fail","Package":"example.com/a"}`,
+			},
+			want: []string{
+				"FAIL example.com/a",
+				"\tTestFoo: foo_test.go:10: oops",
+				"1 tests failed in 1 packages",
+			},
+		},
+	} {
+		got := summarizeGoTest([]byte(strings.Join(tt.out, "\n")))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// syncChanges copies the changed files, as returned by changedFiles, to the
+// --sync destination using rsync.  Removed files are removed from the
+// destination.  The rsync is run as part of j so it is killed with j, but
+// as the user running autocmd and without the limits of commands.  It does
+// nothing if --sync was not specified.
+func syncChanges(j *job, changed []string) error {
+	if flags.Sync == "" || len(changed) == 0 {
+		return nil
+	}
+	list, err := os.CreateTemp("", "autocmd-sync-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	for _, c := range changed {
+		// Each change is of the form "X path".
+		fmt.Fprintln(list, c[2:])
+	}
+	if err := list.Close(); err != nil {
+		return err
+	}
+	cmd, err := j.startHelper([]string{"rsync", "-aR", "--delete-missing-args", "--files-from=" + list.Name(), ".", flags.Sync})
+	if err != nil {
+		return err
+	}
+	if err := j.wait(cmd); err != nil {
+		return fmt.Errorf("rsync: %v", err)
+	}
+	return nil
+}
+
+// syncHost splits the --sync destination into its host and directory.  The
+// host is empty if the destination is local.
+func syncHost() (host, dir string) {
+	dest := flags.Sync
+	x := strings.Index(dest, ":")
+	if x < 0 || strings.Contains(dest[:x], "/") {
+		return "", dest
+	}
+	return dest[:x], dest[x+1:]
+}
+
+// syncCommand returns command unchanged unless --sync-exec was specified, in
+// which case it returns a command that runs command in the --sync destination
+// directory, using ssh if the destination is on another host.  Killing the
+// local ssh does not kill command, so the remote shell records its process
+// ID, which is also that of its process group, and j kills the group when j
+// is killed.
+func syncCommand(j *job, command []string) []string {
+	if !flags.SyncExec {
+		return command
+	}
+	host, dir := syncHost()
+	if dir == "" {
+		dir = "."
+	}
+	var words []string
+	for _, w := range command {
+		words = append(words, shellQuote(w))
+	}
+	script := "cd " + shellQuote(dir) + " && exec " + strings.Join(words, " ")
+	if host == "" {
+		return []string{"/bin/sh", "-c", script}
+	}
+	pidfile := fmt.Sprintf("/tmp/autocmd-%d-%d.pid", os.Getpid(), atomic.AddInt64(&containerSeq, 1))
+	j.atKill(func() {
+		kill := fmt.Sprintf(`pid=$(cat %s) && kill -KILL -- -$pid 2>/dev/null; rm -f %[1]s`, pidfile)
+		exec.Command("ssh", "-o", "BatchMode=yes", host, kill).Run()
+	})
+	script = fmt.Sprintf("echo $$ > %s && cd %s && %s; status=$?; rm -f %[1]s; exit $status", pidfile, shellQuote(dir), strings.Join(words, " "))
+	return []string{"ssh", "-o", "BatchMode=yes", host, script}
+}
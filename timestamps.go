@@ -0,0 +1,18 @@
+package main
+
+// defaultTimestamps is the format used when --timestamps is given without
+// one.
+const defaultTimestamps = "2006-01-02 15:04:05"
+
+// optionalFlags are the flags whose argument may be omitted.  Such an
+// argument can only be given as --flag=ARG.
+var optionalFlags = []string{"timestamps"}
+
+// timestamp returns the current time in the --timestamps format followed by
+// a space, or "" if there is no --timestamps.
+func timestamp() string {
+	if flags.Timestamps == "" {
+		return ""
+	}
+	return now().Format(flags.Timestamps) + " "
+}
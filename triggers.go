@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// probeInterval is how often the --after commands are run.  They are
+// typically slower than checking files, e.g., asking docker about a
+// container.
+const probeInterval = 2 * time.Second
+
+// probeTimeout is how long an --after command may run.  One that hangs,
+// e.g., because docker is not responding, is killed and its output is
+// whatever it wrote.
+const probeTimeout = 10 * time.Second
+
+// afterPids are the processes named by --after-pid that have not yet
+// exited.
+var afterPids []int
+
+// probeChan carries the --after commands whose output changed to the main
+// loop.
+var probeChan = make(chan string, 10)
+
+// startTriggers starts watching the processes named by --after-pid and
+// running the commands named by --after.  Any error is fatal.
+func startTriggers() {
+	for _, arg := range flags.AfterPid {
+		pid, err := strconv.Atoi(arg)
+		if err == nil && pid <= 0 {
+			err = fmt.Errorf("invalid pid")
+		}
+		if err == nil {
+			err = syscall.Kill(pid, 0)
+		}
+		if err != nil && err != syscall.EPERM {
+			fmt.Fprintf(os.Stderr, "--after-pid=%s: %v\n", arg, err)
+			os.Exit(1)
+		}
+		afterPids = append(afterPids, pid)
+	}
+	if flags.DryRun && len(flags.After) > 0 {
+		printf("Not running the --after commands with --dry-run\n")
+		return
+	}
+	for _, probe := range flags.After {
+		go runProbe(probe)
+	}
+}
+
+// probe runs the shell command probe and returns its output and, if it
+// failed, why.  probe and anything it started are killed after
+// probeTimeout.
+func probe(probe string) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	out, err := shellCmd(ctx, probe).CombinedOutput()
+	if err != nil {
+		out = append(out, err.Error()...)
+	}
+	return out
+}
+
+// runProbe runs probe every probeInterval and tells the main loop each time
+// its output differs from the last time.
+func runProbe(p string) {
+	last := probe(p)
+	for {
+		time.Sleep(probeInterval)
+		out := probe(p)
+		if !bytes.Equal(out, last) {
+			probeChan <- p
+		}
+		last = out
+	}
+}
+
+// checkTriggers forces all sets to run if a process named by --after-pid
+// has exited or the output of a command named by --after has changed.  It
+// must only be called from the main loop.
+func checkTriggers() {
+	var why []string
+	pids := afterPids[:0]
+	for _, pid := range afterPids {
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			why = append(why, fmt.Sprintf("process %d exited", pid))
+		} else {
+			pids = append(pids, pid)
+		}
+	}
+	afterPids = pids
+Probes:
+	for {
+		select {
+		case p := <-probeChan:
+			why = append(why, fmt.Sprintf("output of %q changed", p))
+		default:
+			break Probes
+		}
+	}
+	if len(why) == 0 {
+		return
+	}
+	for _, w := range why {
+		printf("%s %s\n", now(), w)
+	}
+	for _, s := range allSets() {
+		s.forced = true
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// maxTUILines is the most lines of output the TUI remembers.
+const maxTUILines = 10000
+
+// ui is the dashboard shown with --tui, or nil.
+var ui *tui
+
+// A tui is a full screen dashboard.  The top of the screen lists the sets
+// and the state of their last run, the rest is divided into panes showing
+// the most recent output of autocmd and of the commands of each set.
+type tui struct {
+	mu      sync.Mutex
+	tty     *os.File
+	stty    string               // the terminal settings to restore
+	log     *pane                // the output of autocmd itself
+	panes   map[string]*pane     // the output of each set, by set name
+	states  map[string]*setState // by set name
+	focus   string               // the name of the focused pane
+	zoomed  bool                 // only the focused pane is shown
+	paused  bool
+	dirty   bool
+	stopped bool
+}
+
+// A pane holds the output shown in one pane of the TUI.
+type pane struct {
+	t       *tui
+	name    string
+	lines   []string
+	partial []byte
+	scroll  int // how many lines the pane is scrolled back
+}
+
+// A setState is the state of a set shown by the TUI.
+type setState struct {
+	state string // idle, running, pass, fail, killed, or unhealthy
+	start time.Time
+	last  time.Duration // how long the last run took
+}
+
+// startTUI takes over the terminal for --tui.  All output is sent to the
+// TUI rather than written directly to the terminal.
+func startTUI() error {
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("the standard input is not a terminal")
+	}
+	if !isTerminal(os.Stdout) {
+		return fmt.Errorf("the standard output is not a terminal")
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return err
+	}
+	ui = &tui{
+		tty:    os.Stdout,
+		stty:   strings.TrimSpace(saved),
+		panes:  map[string]*pane{},
+		states: map[string]*setState{},
+		focus:  "autocmd",
+		dirty:  true,
+	}
+	ui.log = &pane{t: ui, name: "autocmd"}
+	for _, s := range allSets() {
+		ui.states[s.String()] = &setState{state: "idle"}
+	}
+	// Use the alternate screen and hide the cursor.
+	fmt.Fprint(ui.tty, "\033[?1049h\033[?25l")
+	stdout, stderr = ui.log, ui.log
+	cmdStdout, cmdStderr = ui.log, ui.log
+	// Each set's pane is cleared when it runs, see output.
+	clear = func() {}
+	go ui.readKeys()
+	go ui.refresh()
+	return nil
+}
+
+// stty runs stty(1) with args on the terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty: %v", err)
+	}
+	return string(out), nil
+}
+
+// stopTUI restores the terminal, if --tui took it over.  Nothing is shown
+// once it has been called.  It is called by exit.
+func stopTUI() {
+	if ui == nil {
+		return
+	}
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if ui.stopped {
+		return
+	}
+	ui.stopped = true
+	fmt.Fprint(ui.tty, "\033[?25h\033[?1049l")
+	stty(ui.stty)
+}
+
+// exit exits with code after restoring the terminal.  Once the TUI might
+// have been started autocmd must exit by calling exit rather than os.Exit.
+func exit(code int) {
+	stopTUI()
+	os.Exit(code)
+}
+
+// fatalf restores the terminal, so the message is not lost with the TUI,
+// prints the message to the standard error, and exits.
+func fatalf(format string, v ...interface{}) {
+	stopTUI()
+	fmt.Fprintf(os.Stderr, format, v...)
+	exit(1)
+}
+
+// restoreTerminal is deferred by main so a panic does not leave the
+// terminal showing the TUI with echo turned off.  The panic continues once
+// the terminal is restored.
+func restoreTerminal() {
+	if v := recover(); v != nil {
+		stopTUI()
+		panic(v)
+	}
+}
+
+// readKeys handles the keys pressed while the TUI is shown.  The r, p, and
+// q keys send autocmd the same signals that can be sent from outside, the
+// rest move between and scroll the panes.
+func (t *tui) readKeys() {
+	var b [1]byte
+	var esc []byte // the escape sequence read so far
+	for {
+		if n, err := os.Stdin.Read(b[:]); err != nil || n == 0 {
+			return
+		}
+		key := string(b[:])
+		switch {
+		case b[0] == '\033':
+			esc = []byte{b[0]}
+			continue
+		case len(esc) > 0:
+			esc = append(esc, b[0])
+			if len(esc) == 2 || b[0] < '@' || b[0] > '~' {
+				continue
+			}
+			key, esc = string(esc), nil
+		}
+		switch key {
+		case "r":
+			intChan <- syscall.SIGUSR1
+		case "p":
+			intChan <- syscall.SIGUSR2
+		case "q":
+			intChan <- syscall.SIGTERM
+		default:
+			t.mu.Lock()
+			t.key(key)
+			t.dirty = true
+			t.mu.Unlock()
+		}
+	}
+}
+
+// key handles a key that changes what t shows.  t must be locked.
+//
+//	tab		focus the next pane
+//	z		show only the focused pane, or all the panes
+//	k, up		scroll the focused pane back a line
+//	j, down		scroll the focused pane forward a line
+//	page up/down	scroll the focused pane by half a screen
+//	G		stop scrolling, follow the output again
+func (t *tui) key(key string) {
+	names := t.paneNames()
+	p := t.pane(t.focus)
+	rows, _ := termSize(t.tty)
+	switch key {
+	case "\t":
+		for i, name := range names {
+			if name == t.focus {
+				t.focus = names[(i+1)%len(names)]
+				break
+			}
+		}
+	case "z":
+		t.zoomed = !t.zoomed
+	case "k", "\033[A":
+		p.scroll++
+	case "j", "\033[B":
+		p.scroll--
+	case "\033[5~":
+		p.scroll += rows / 2
+	case "\033[6~":
+		p.scroll -= rows / 2
+	case "G":
+		p.scroll = 0
+	}
+	p.scroll = min(max(p.scroll, 0), len(p.lines))
+}
+
+// paneNames returns the names of the panes of t, autocmd's own first and
+// then those of the sets.
+func (t *tui) paneNames() []string {
+	names := []string{t.log.name}
+	for _, s := range allSets() {
+		names = append(names, s.String())
+	}
+	return names
+}
+
+// pane returns the pane called name, creating it if need be.  t must be
+// locked.
+func (t *tui) pane(name string) *pane {
+	if name == t.log.name {
+		return t.log
+	}
+	p := t.panes[name]
+	if p == nil {
+		p = &pane{t: t, name: name}
+		t.panes[name] = p
+	}
+	return p
+}
+
+// setOutput returns the writer for the output of the commands of s, which
+// writes to the pane of s.  With --clear the pane is cleared first.
+func (t *tui) setOutput(s *set) io.Writer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.pane(s.String())
+	if flags.Clear {
+		p.lines, p.partial, p.scroll = p.lines[:0], p.partial[:0], 0
+		t.dirty = true
+	}
+	return p
+}
+
+// Write adds p to the output shown by the pane.
+func (p *pane) Write(b []byte) (int, error) {
+	t := p.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data := append(p.partial, b...)
+	n := len(p.lines)
+	for {
+		x := bytes.IndexByte(data, '\n')
+		if x < 0 {
+			break
+		}
+		p.lines = append(p.lines, strings.TrimRight(string(data[:x]), "\r"))
+		data = data[x+1:]
+	}
+	p.partial = append([]byte{}, data...)
+	if p.scroll > 0 {
+		// Keep showing the same lines.
+		p.scroll += len(p.lines) - n
+	}
+	if len(p.lines) > maxTUILines {
+		p.lines = append(p.lines[:0], p.lines[len(p.lines)-maxTUILines:]...)
+		p.scroll = min(p.scroll, len(p.lines))
+	}
+	t.dirty = true
+	return len(b), nil
+}
+
+// setState records that the run of the set described by r is now in state.
+// It does nothing if there is no TUI.
+func (t *tui) setState(state string, r *result) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.states[r.Set]
+	if st == nil {
+		st = &setState{}
+		t.states[r.Set] = st
+	}
+	st.state = state
+	switch state {
+	case "running":
+		st.start = r.Start
+	case "unhealthy":
+	default:
+		st.last = time.Duration(r.Duration * float64(time.Second))
+	}
+	t.dirty = true
+}
+
+// setPaused records whether autocmd is paused.  It does nothing if there is
+// no TUI.
+func (t *tui) setPaused(paused bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = paused
+	t.dirty = true
+}
+
+// refresh redraws t when it has changed, or a set is running so its time
+// must be updated, and when the terminal is resized.
+func (t *tui) refresh() {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	tick := time.NewTicker(100 * time.Millisecond)
+	for n := 0; ; n++ {
+		select {
+		case <-winch:
+			t.mu.Lock()
+			t.dirty = true
+			t.mu.Unlock()
+		case <-tick.C:
+		}
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			return
+		}
+		if t.dirty || n%10 == 0 {
+			t.draw()
+			t.dirty = false
+		}
+		t.mu.Unlock()
+	}
+}
+
+// stateColors are the SGR parameters used to show each state.
+var stateColors = map[string]string{
+	"running":   "33",
+	"pass":      "32",
+	"fail":      "31",
+	"killed":    "35",
+	"unhealthy": "31",
+}
+
+// draw draws t on the terminal.  t must be locked.
+func (t *tui) draw() {
+	rows, cols := termSize(t.tty)
+	var b strings.Builder
+	b.WriteString("\033[H")
+	line := func(s string) {
+		b.WriteString(truncate(s, cols))
+		b.WriteString("\033[0m\033[K\n")
+	}
+	header := " autocmd   r: run all   p: pause   q: quit   tab: next pane   z: zoom   j/k: scroll"
+	if t.paused {
+		header += "   PAUSED"
+	}
+	line("\033[7m" + header + strings.Repeat(" ", max(cols-len(header), 0)))
+	sets := allSets()
+	for _, s := range sets {
+		st := t.states[s.String()]
+		if st == nil {
+			st = &setState{state: "idle"}
+		}
+		d := st.last
+		if st.state == "running" {
+			d = now().Sub(st.start)
+		}
+		dur := ""
+		if d > 0 {
+			dur = d.Round(10 * time.Millisecond).String()
+		}
+		state := fmt.Sprintf("%-9s", st.state)
+		if c := stateColors[st.state]; c != "" {
+			state = "\033[" + c + "m" + state + "\033[0m"
+		}
+		line(fmt.Sprintf(" %-20s %s %8s  %s", s, state, dur, strings.Join(s.command, " ")))
+	}
+	// The panes take the rest of the screen, each below a title.  The
+	// last line is left empty so the terminal does not scroll.
+	names := t.paneNames()
+	if t.zoomed {
+		names = []string{t.focus}
+	}
+	height := rows - len(sets) - 2
+	for i, name := range names {
+		p := t.pane(name)
+		h := height/len(names) - 1
+		if i == len(names)-1 {
+			h = height - (height/len(names))*(len(names)-1) - 1
+		}
+		title := "── " + name + " "
+		if p.scroll > 0 {
+			title += fmt.Sprintf("(%d lines back) ", p.scroll)
+		}
+		title += strings.Repeat("─", max(cols-utf8.RuneCountInString(title), 0))
+		if name == t.focus {
+			title = "\033[1m" + title
+		}
+		line(title)
+		p.draw(h, line)
+	}
+	b.WriteString("\033[J")
+	t.tty.WriteString(b.String())
+}
+
+// draw draws the last height lines of p, allowing for scrolling, using
+// line.  p.t must be locked.
+func (p *pane) draw(height int, line func(string)) {
+	if height <= 0 {
+		return
+	}
+	lines := p.lines
+	if len(p.partial) > 0 {
+		lines = append(lines[:len(lines):len(lines)], string(p.partial))
+	}
+	lines = lines[:len(lines)-min(p.scroll, len(lines))]
+	if len(lines) > height {
+		lines = lines[len(lines)-height:]
+	}
+	for _, l := range lines {
+		line(l)
+	}
+	for i := len(lines); i < height; i++ {
+		line("")
+	}
+}
+
+// truncate returns s truncated to width columns.  Escape sequences take no
+// columns.  Each rune is assumed to take a single column.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	cols := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		case r == '\033':
+			inEscape = true
+		case r == '\t':
+			n := 8 - cols%8
+			if cols+n > width {
+				return b.String()
+			}
+			b.WriteString(strings.Repeat(" ", n))
+			cols += n
+			continue
+		case r < ' ' || r == utf8.RuneError:
+			continue
+		default:
+			if cols == width {
+				return b.String()
+			}
+			cols++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// termSize returns the number of rows and columns of the terminal tty.
+func termSize(tty *os.File) (rows, cols int) {
+	var ws struct{ row, col, x, y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.row == 0 || ws.col == 0 {
+		return 24, 80
+	}
+	return int(ws.row), int(ws.col)
+}
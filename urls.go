@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// urlInterval is how often watched URLs are fetched.  Unlike files, they
+// are usually on someone else's server.
+const urlInterval = 10 * time.Second
+
+// A urlWatch polls a URL watched by url= or --url.  version is incremented
+// each time the resource changes.
+type urlWatch struct {
+	url      string
+	stop     chan struct{} // closed when nothing watches url any more
+	mu       sync.Mutex
+	version  int
+	etag     string   // ETag of the last response
+	modified string   // Last-Modified of the last response
+	sum      [32]byte // hash of the body of the last response
+	fetched  bool     // a response has been received
+	failing  bool     // the last fetch failed, and was reported
+}
+
+var (
+	urlMu      sync.Mutex
+	urlWatches = map[string]*urlWatch{}
+	urlClient  = &http.Client{Timeout: 30 * time.Second}
+)
+
+// checkURL returns an error if u, the value of url= or --url, is not an
+// http or https URL.
+func checkURL(u string) error {
+	if u == "" {
+		return fmt.Errorf("empty URL")
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return fmt.Errorf("%s: not an http or https URL", u)
+	}
+	return nil
+}
+
+// urlVersion returns the version of url, starting to watch it if it is not
+// already being watched.
+func urlVersion(url string) int {
+	urlMu.Lock()
+	w := urlWatches[url]
+	if w == nil {
+		w = &urlWatch{url: url, stop: make(chan struct{})}
+		urlWatches[url] = w
+		go w.poll()
+	}
+	urlMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.version
+}
+
+// poll fetches w every urlInterval until w is stopped.
+func (w *urlWatch) poll() {
+	for {
+		w.fetch()
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(urlInterval):
+		}
+	}
+}
+
+// pruneURLWatches stops watching the URLs that are not watched by any of
+// sets, e.g., because the config that named them changed.
+func pruneURLWatches(sets []*set) {
+	used := map[string]bool{}
+	for _, s := range sets {
+		for _, src := range s.sources {
+			if u, ok := src.(*urlSource); ok {
+				used[u.url] = true
+			}
+		}
+	}
+	urlMu.Lock()
+	defer urlMu.Unlock()
+	for url, w := range urlWatches {
+		if !used[url] {
+			close(w.stop)
+			delete(urlWatches, url)
+		}
+	}
+}
+
+// fetch fetches w and increments its version if the resource changed.  The
+// ETag and Last-Modified headers are used to avoid fetching an unchanged
+// resource, and the body is compared in case they are missing or wrong.
+func (w *urlWatch) fetch() {
+	req, err := http.NewRequest("GET", w.url, nil)
+	if err != nil {
+		w.failed(err.Error())
+		return
+	}
+	w.mu.Lock()
+	if w.etag != "" {
+		req.Header.Set("If-None-Match", w.etag)
+	}
+	if w.modified != "" {
+		req.Header.Set("If-Modified-Since", w.modified)
+	}
+	w.mu.Unlock()
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		w.failed(err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		w.succeeded()
+		return
+	case resp.StatusCode/100 != 2:
+		w.failed(resp.Status)
+		return
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		w.failed(err.Error())
+		return
+	}
+	w.succeeded()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var sum [32]byte
+	h.Sum(sum[:0])
+	if w.fetched && sum != w.sum {
+		w.version++
+	}
+	w.sum, w.fetched = sum, true
+	w.etag = resp.Header.Get("ETag")
+	w.modified = resp.Header.Get("Last-Modified")
+}
+
+// failed reports that fetching w failed, unless the previous fetch also
+// failed.
+func (w *urlWatch) failed(why string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.failing {
+		warnf("%s: %s\n", w.url, why)
+		w.failing = true
+	}
+}
+
+// succeeded reports that w can be fetched again after failing.
+func (w *urlWatch) succeeded() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		printf("%s: ok\n", w.url)
+		w.failing = false
+	}
+}
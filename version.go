@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is the version of autocmd.  It may be set when building with
+//
+//	go build -ldflags "-X main.version=VERSION"
+//
+// otherwise the module version recorded in the binary is used.
+var version string
+
+// defaultFlags are the values of flags before the command line is parsed.
+var defaultFlags = flags
+
+// A buildInfo describes how autocmd was built.
+type buildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// readBuildInfo returns how autocmd was built.
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		if b.Version == "" {
+			b.Version = "unknown"
+		}
+		return b
+	}
+	b.GoVersion = bi.GoVersion
+	if b.Version == "" {
+		b.Version = bi.Main.Version
+	}
+	if b.Version == "" {
+		b.Version = "(devel)"
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// printVersion writes the version of autocmd, and how it was built, to w.
+func printVersion(w io.Writer) {
+	b := readBuildInfo()
+	fmt.Fprintf(w, "autocmd %s", b.Version)
+	if b.Revision != "" {
+		rev := b.Revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if b.Modified {
+			rev += "+modified"
+		}
+		fmt.Fprintf(w, " %s", rev)
+		if b.Time != "" {
+			fmt.Fprintf(w, " %s", b.Time)
+		}
+	}
+	if b.GoVersion != "" {
+		fmt.Fprintf(w, " %s", b.GoVersion)
+	}
+	fmt.Fprintln(w)
+}
+
+// A flagInfo describes a command line flag for --help-json.
+type flagInfo struct {
+	Name    string      `json:"name"`
+	Short   string      `json:"short,omitempty"`
+	Arg     string      `json:"arg,omitempty"`
+	Type    string      `json:"type"` // bool, string, int, duration, or list
+	Default interface{} `json:"default,omitempty"`
+	Values  []string    `json:"values,omitempty"` // the permitted values, if limited
+	Help    string      `json:"help"`
+}
+
+// flagValues are the permitted values of the flags that take one of a fixed
+// set of values.
+var flagValues = map[string][]string{
+	"completion":     {"bash", "zsh", "fish"},
+	"on-battery":     {"run", "delay", "skip"},
+	"only-type":      {"f", "d"},
+	"stat":           {"basic", "ctime", "full"},
+	"summarize":      {"go-test", "problems", "go", "gcc", "tsc"},
+	"timeout-action": {"kill", "warn"},
+}
+
+// A helpInfo is what --help-json writes.  Everything but the flags, which
+// are taken from the flags struct, must be kept up to date by hand.
+type helpInfo struct {
+	Build        buildInfo         `json:"build"`
+	Usage        string            `json:"usage"`
+	Flags        []flagInfo        `json:"flags"`
+	SetOptions   map[string]string `json:"set_options"`
+	Placeholders map[string]string `json:"placeholders"`
+	Subcommands  map[string]string `json:"subcommands"`
+}
+
+// helpJSON writes a description of the flags, and other features, of
+// autocmd to w as JSON.
+func helpJSON(w io.Writer) error {
+	h := helpInfo{
+		Build: readBuildInfo(),
+		Usage: "autocmd [FLAGS] [OPTION=VALUE ...] PATTERN [...] -- CMD [...] [--- ...]",
+		Flags: flagInfos(),
+		SetOptions: map[string]string{
+			"name":      "NAME: name the set",
+			"after":     "SET[,SET]: run after the named sets",
+			"produces":  "PATTERN[,PATTERN]: files the command produces",
+			"on":        "commit: run when a git commit is made, no patterns",
+			"frequency": "DUR: check the set's files every DUR",
+			"every":     "DUR: also run the set every DUR even if nothing changed",
+			"url":       "URL[,URL]: also run the set when a URL changes",
+			"if":        "CMD: only run the set for changes if the shell command CMD succeeds",
+		},
+		Placeholders: map[string]string{
+			"{file}":  "the first file added or changed",
+			"{files}": "all the files added or changed",
+			"{dir}":   "the directory of {file}",
+			"{base}":  "the name of {file} without its directory or extension",
+			"{ext}":   "the extension of {file}, including the .",
+			"{time}":  "the current time in RFC 3339 format",
+		},
+		Subcommands: map[string]string{
+			"attach":          "attach: show the output of the running autocmd",
+			"bench":           "bench [PATTERN ...]: time scan passes",
+			"config":          "config migrate [PATH]: upgrade a config to the current version",
+			"init":            "init [PATH]: write a config for the current directory",
+			"install-service": "install-service [ARGS]: run this autocmd as a service at login",
+			"history":         "history: show the recent runs of the running autocmd",
+			"files":           "files: show how many files each set of the running autocmd watches",
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&h)
+}
+
+// flagInfos returns a description of each field of flags, as parsed from
+// its getopt tag.
+func flagInfos() []flagInfo {
+	var infos []flagInfo
+	v := reflect.ValueOf(defaultFlags)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		words := strings.Fields(t.Field(i).Tag.Get("getopt"))
+		var fi flagInfo
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			switch w := words[0]; {
+			case strings.HasPrefix(w, "--"):
+				fi.Name, fi.Arg, _ = strings.Cut(w[2:], "=")
+			default:
+				fi.Short = w[1:]
+			}
+			words = words[1:]
+		}
+		fi.Help = strings.Join(words, " ")
+		fi.Values = flagValues[fi.Name]
+		switch f := v.Field(i).Interface().(type) {
+		case bool:
+			fi.Type = "bool"
+			if f {
+				fi.Default = f
+			}
+		case string:
+			fi.Type = "string"
+			if f != "" {
+				fi.Default = f
+			}
+		case int:
+			fi.Type = "int"
+			if f != 0 {
+				fi.Default = f
+			}
+		case float64:
+			fi.Type = "float"
+			if f != 0 {
+				fi.Default = f
+			}
+		case time.Duration:
+			fi.Type = "duration"
+			if f != 0 {
+				fi.Default = f.String()
+			}
+		case []string:
+			fi.Type = "list"
+			if len(f) > 0 {
+				fi.Default = f
+			}
+		}
+		infos = append(infos, fi)
+	}
+	return infos
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A watchman finds files using a running Watchman daemon (--watchman) rather
+// than by reading directories.  Watchman is asked for the files that changed
+// since the last query each --frequency, so each pass costs about the same no
+// matter how large the tree is.  Patterns are matched against the files
+// Watchman reported.
+type watchman struct {
+	dir   string // the directory watched
+	conn  net.Conn
+	r     *bufio.Reader
+	root  string // the root of the watch
+	rel   string // the current directory relative to root
+	clock string // the clock returned by the last query
+
+	mu    sync.Mutex
+	files map[string]os.FileInfo // with dir prepended to their names
+}
+
+// A watchmanFile is a file as returned by a watchman query.
+type watchmanFile struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size"`
+	Mode   uint32 `json:"mode"`
+	Mtime  int64  `json:"mtime_ns"`
+}
+
+// newWatchman returns a source that connects to watchman and has it watch
+// dir.  It waits for the first list of files to arrive.
+func newWatchman(dir, arg string) (ChangeSource, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("watchman takes no argument")
+	}
+	w := &watchman{dir: dir}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	if err := w.query(); err != nil {
+		w.conn.Close()
+		return nil, err
+	}
+	go w.watch()
+	return w, nil
+}
+
+// connect connects w to watchman and starts watching w.dir.
+func (w *watchman) connect() error {
+	sock := os.Getenv("WATCHMAN_SOCK")
+	if sock == "" {
+		out, err := exec.Command("watchman", "--output-encoding=json", "--no-pretty", "get-sockname").Output()
+		if err != nil {
+			return fmt.Errorf("watchman get-sockname: %v", err)
+		}
+		var resp struct {
+			Sockname string `json:"sockname"`
+		}
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return fmt.Errorf("watchman get-sockname: %v", err)
+		}
+		sock = resp.Sockname
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	w.r = bufio.NewReader(conn)
+	dir, err := filepath.Abs(w.dir)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var resp struct {
+		Watch        string `json:"watch"`
+		RelativePath string `json:"relative_path"`
+	}
+	if err := w.call(&resp, "watch-project", dir); err != nil {
+		conn.Close()
+		return err
+	}
+	w.root, w.rel, w.clock = resp.Watch, resp.RelativePath, ""
+	return nil
+}
+
+// call sends the command args to watchman and decodes the response into
+// resp.  Unilateral responses, such as log messages, are skipped.
+func (w *watchman) call(resp interface{}, args ...interface{}) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	w.conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := w.conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	for {
+		line, err := w.r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		var status struct {
+			Error      string `json:"error"`
+			Unilateral bool   `json:"unilateral"`
+		}
+		if err := json.Unmarshal(line, &status); err != nil {
+			return err
+		}
+		switch {
+		case status.Unilateral:
+			continue
+		case status.Error != "":
+			return fmt.Errorf("watchman %v: %s", args[0], status.Error)
+		}
+		return json.Unmarshal(line, resp)
+	}
+}
+
+// query asks watchman for the files that changed since the last query, or
+// for all files if there was no last query, and updates w.files.
+func (w *watchman) query() error {
+	q := map[string]interface{}{
+		"fields": []string{"name", "exists", "size", "mode", "mtime_ns"},
+	}
+	if w.rel != "" {
+		q["relative_root"] = w.rel
+	}
+	if w.clock != "" {
+		q["since"] = w.clock
+	}
+	var resp struct {
+		Clock string         `json:"clock"`
+		Fresh bool           `json:"is_fresh_instance"`
+		Files []watchmanFile `json:"files"`
+	}
+	if err := w.call(&resp, "query", w.root, q); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if resp.Fresh || w.files == nil {
+		w.files = map[string]os.FileInfo{}
+	}
+	for _, f := range resp.Files {
+		name := filepath.Join(w.dir, f.Name)
+		if !f.Exists || watchmanIgnored(f.Name) {
+			delete(w.files, name)
+			continue
+		}
+		w.files[name] = &fileInfo{
+			name:  name,
+			size:  f.Size,
+			mode:  watchmanMode(f.Mode),
+			mtime: time.Unix(0, f.Mtime),
+		}
+	}
+	w.clock = resp.Clock
+	return nil
+}
+
+// watch queries watchman each --frequency, reconnecting if watchman goes
+// away.
+func (w *watchman) watch() {
+	for {
+		time.Sleep(flags.Frequency)
+		err := w.query()
+		if err == nil {
+			continue
+		}
+		warnf("watchman: %v, reconnecting\n", err)
+		w.conn.Close()
+		for w.connect() != nil {
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// watchmanIgnored returns true if name is in a directory that ... does not
+// descend into.
+func watchmanIgnored(name string) bool {
+	elems := strings.Split(name, "/")
+	for _, e := range elems[:len(elems)-1] {
+		if ignoreDir(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchmanMode converts a unix mode, as returned by watchman, to an
+// os.FileMode.
+func watchmanMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0100000:
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	default:
+		m |= os.ModeIrregular
+	}
+	return m
+}
+
+// glob adds the files watchman reported that match any of patterns to f.
+func (w *watchman) glob(patterns []string, f map[string]os.FileInfo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	globFiles(w.files, patterns, f)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// xdgDir returns the value of the XDG base directory environment variable
+// env, or, if it is not set or not absolute, def relative to the home
+// directory.
+func xdgDir(env, def string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, def)
+}
+
+// defaultConfig returns the path of the default config,
+// $XDG_CONFIG_HOME/autocmd/config.  A config at $HOME/.config/autocmd,
+// where it used to be, is still used if there is not one in the new place.
+func defaultConfig() string {
+	path := filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "autocmd", "config")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	legacy := filepath.Join(home, ".config", "autocmd")
+	if fi, err := os.Stat(legacy); err == nil && fi.Mode().IsRegular() {
+		return legacy
+	}
+	return path
+}
+
+// stateDir returns the directory in $XDG_STATE_HOME that holds the state of
+// autocmd in the current directory.  It is named after the current
+// directory, with a hash of its path so that two projects of the same name
+// do not share a directory.
+func stateDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(xdgDir("XDG_STATE_HOME", ".local/state"), "autocmd", fmt.Sprintf("%s-%x", filepath.Base(dir), sum[:4]))
+}
+
+// stateDefault is the file given to a flag in stateFlags to use its default
+// file.  The flags require a file so that --state PATH is not mistaken for
+// --state followed by a pattern.
+const stateDefault = "@"
+
+// stateFlags are the flags that name a file which, if the flag is given
+// stateDefault, defaults to file in stateDir.
+var stateFlags = []struct {
+	name string
+	path *string
+	file string
+}{
+	{"state", &flags.State, "state"},
+	{"history-file", &flags.HistoryFile, "history"},
+	{"log-file", &flags.LogFile, "log"},
+}
+
+// setStatePaths sets the flags in stateFlags that were given stateDefault to
+// their default file, and creates the directories of the files named by
+// all the flags in stateFlags.
+func setStatePaths() error {
+	for _, f := range stateFlags {
+		if !getopt.IsSet(f.name) {
+			continue
+		}
+		if *f.path == stateDefault {
+			*f.path = filepath.Join(stateDir(), f.file)
+		}
+		if err := os.MkdirAll(filepath.Dir(*f.path), 0755); err != nil {
+			return fmt.Errorf("--%s: %v", f.name, err)
+		}
+	}
+	return nil
+}